@@ -0,0 +1,176 @@
+// jtltp-get sends one request to a JTLTP server and prints the status, type,
+// headers and body of the answer, like curl does for HTTP.
+//
+//	jtltp-get jtltp://localhost:8080/index.jtl
+//	jtltp-get -X POST -H "x-user: james" -d "james=42" localhost:8080/scores
+//	jtltp-get -X PUT -data-file page.jtl jtltp://localhost:8080/page.jtl
+//	echo hello | jtltp-get -X POST -data-file - -v localhost:8080/echo
+//	jtltp-get -X SUBSCRIBE localhost:8080/live/scores
+//
+// A URL without a scheme is taken as jtltp://.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"jtlweb/stuff/jtltp"
+)
+
+// headerList collects -H flags, as "name: value" or "name=value"
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	if !strings.ContainsAny(value, ":=") {
+		return errors.New(`expected "name: value"`)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	method := flag.String("X", jtltp.MethodGet, "verb to send: GET, POST, PUT or SUBSCRIBE")
+	var headers headerList
+	flag.Var(&headers, "H", `header to send, as "name: value". may be repeated`)
+	data := flag.String("d", "", "body to send")
+	dataFile := flag.String("data-file", "", "file to send as the body, - for stdin")
+	timeout := flag.Duration("timeout", 30*time.Second, "give up after this long, subscriptions only time out while connecting")
+	verbose := flag.Bool("v", false, "dump everything sent and received to stderr")
+	bodyOnly := flag.Bool("s", false, "print only the body")
+	compressed := flag.Bool("compressed", false, "ask for a compressed answer, decompressed before printing")
+	insecure := flag.Bool("k", false, "don't verify jtltps:// certificates")
+	caFile := flag.String("cacert", "", "PEM file of certificates to trust for jtltps://")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] url\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rawurl := flag.Arg(0)
+	if !jtltp.IsURL(rawurl) {
+		rawurl = "jtltp://" + rawurl
+	}
+
+	body, err := readBody(*data, *dataFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading body: ", err)
+		os.Exit(1)
+	}
+
+	req := jtltp.NewRequest(strings.ToUpper(*method), "", body)
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		if strings.Contains(name, "=") {
+			name, value, _ = strings.Cut(header, "=")
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := &jtltp.Client{
+		DialTimeout:        *timeout,
+		ReadTimeout:        *timeout,
+		WriteTimeout:       *timeout,
+		DisableCompression: !*compressed,
+		TLSConfig:          &tls.Config{InsecureSkipVerify: *insecure},
+	}
+	if *caFile != "" {
+		pem, err := os.ReadFile(*caFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading -cacert: ", err)
+			os.Exit(1)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fmt.Fprintf(os.Stderr, "Error: no certificates in %s\n", *caFile)
+			os.Exit(1)
+		}
+		client.TLSConfig.RootCAs = pool
+	}
+	if *verbose {
+		client.Dump = os.Stderr
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if req.Method == jtltp.MethodSubscribe {
+		err := client.Subscribe(ctx, rawurl, func(message []byte) {
+			os.Stdout.Write(message)
+			fmt.Println()
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Error: ", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	resp, err := client.DoURL(ctx, rawurl, req)
+	var statusErr *jtltp.StatusError
+	if err != nil && !errors.As(err, &statusErr) {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		os.Exit(1)
+	}
+
+	if *verbose {
+		// the dump doesn't end with a newline when the body doesn't
+		fmt.Fprintln(os.Stderr)
+	}
+	if !*bodyOnly {
+		printHeader(resp)
+	}
+	os.Stdout.Write(resp.Body)
+}
+
+// readBody returns -d, or the contents of -data-file
+func readBody(data string, dataFile string) ([]byte, error) {
+	switch {
+	case data != "" && dataFile != "":
+		return nil, errors.New("-d and -data-file can't be used together")
+	case dataFile == "-":
+		return io.ReadAll(os.Stdin)
+	case dataFile != "":
+		return os.ReadFile(dataFile)
+	case data != "":
+		return []byte(data), nil
+	}
+	return nil, nil
+}
+
+func printHeader(resp *jtltp.Response) {
+	fmt.Printf("status: %d\n", resp.Status)
+	fmt.Printf("type: %s\n", resp.Type)
+	if resp.URL != "" {
+		fmt.Printf("url: %s\n", resp.URL)
+	}
+
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, resp.Header[name])
+	}
+	fmt.Println()
+}
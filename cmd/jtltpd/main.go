@@ -0,0 +1,149 @@
+// jtltpd serves a directory of .jtl pages and their assets over JTLTP, or
+// over TLS for jtltps:// when given a certificate.
+//
+//	jtltpd -addr localhost:8080 -root ./site
+//	jtltpd -addr localhost:8443 -root ./site -cert cert.pem -key key.pem
+//	jtltpd -root ./site -allow 10.0.0.0/8 -rate 5
+//	jtltpd -root ./default -host blog.example.com=./blog -host *.example.com=./shop
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"jtlweb/stuff/jtltp"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	root := flag.String("root", ".", "directory to serve")
+	certFile := flag.String("cert", "", "TLS certificate file, serves jtltps:// when set")
+	keyFile := flag.String("key", "", "TLS key file")
+	keepAlive := flag.Bool("keepalive", true, "let clients reuse connections")
+	maxAge := flag.Int("max-age", 0, "seconds clients may use a file without asking again")
+	compress := flag.Bool("compress", true, "gzip or deflate bodies for clients that accept it")
+	logRequests := flag.Bool("log", true, "log every request")
+	allow := flag.String("allow", "", "comma separated addresses or CIDR ranges allowed to connect, everyone when empty")
+	deny := flag.String("deny", "", "comma separated addresses or CIDR ranges refused")
+	rate := flag.Float64("rate", 0, "requests per second allowed per client, unlimited when 0")
+	burst := flag.Int("burst", 20, "requests a client may make at once before -rate applies")
+	var hosts hostList
+	flag.Var(&hosts, "host", "serve a directory for one host name, as name=dir. may be repeated, -root serves every other host")
+	flag.Parse()
+
+	if (*certFile == "") != (*keyFile == "") {
+		fmt.Println("Error: -cert and -key go together")
+		os.Exit(1)
+	}
+
+	checkDir(*root)
+	for _, host := range hosts {
+		checkDir(host.root)
+	}
+
+	filter, err := jtltp.FilterIPs(splitList(*allow), splitList(*deny))
+	if err != nil {
+		fmt.Println("Error: ", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var handler jtltp.Handler = jtltp.FileServer(*root)
+	if len(hosts) > 0 {
+		mux := jtltp.NewHostMux()
+		for _, host := range hosts {
+			mux.Handle(host.name, jtltp.FileServer(host.root))
+			fmt.Printf("Serving %s for %s\n", host.root, host.name)
+		}
+		mux.Handle("*", handler)
+		handler = mux
+	}
+	if *maxAge > 0 {
+		files := handler
+		cacheControl := fmt.Sprintf("max-age=%d", *maxAge)
+		handler = jtltp.HandlerFunc(func(w jtltp.ResponseWriter, r *jtltp.Request) {
+			w.Header().Set("cache-control", cacheControl)
+			files.ServeJTLTP(w, r)
+		})
+	}
+
+	if *compress {
+		handler = jtltp.Compress(handler)
+	}
+
+	// logged first so refused requests show up too
+	var middleware []jtltp.Middleware
+	if *logRequests {
+		middleware = append(middleware, jtltp.LogRequests(nil))
+	}
+	middleware = append(middleware, filter)
+	if *rate > 0 {
+		middleware = append(middleware, jtltp.RateLimit(*rate, *burst))
+	}
+	handler = jtltp.Chain(handler, middleware...)
+
+	server := &jtltp.Server{Handler: handler, KeepAlive: *keepAlive}
+	if *certFile != "" {
+		fmt.Printf("Serving %s on jtltps://%s\n", *root, *addr)
+		err = server.ListenAndServeTLS(ctx, *addr, *certFile, *keyFile)
+	} else {
+		fmt.Printf("Serving %s on jtltp://%s\n", *root, *addr)
+		err = server.ListenAndServe(ctx, *addr)
+	}
+	if err != nil && !errors.Is(err, jtltp.ErrServerClosed) {
+		fmt.Println("Error serving: ", err)
+		os.Exit(1)
+	}
+}
+
+// splitList turns "a, b" into [a b]
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+func checkDir(dir string) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		fmt.Println("Error opening root: ", err)
+		os.Exit(1)
+	}
+	if !info.IsDir() {
+		fmt.Printf("Error: %s is not a directory\n", dir)
+		os.Exit(1)
+	}
+}
+
+// hostList collects -host name=dir flags
+type hostList []struct{ name, root string }
+
+func (h *hostList) String() string {
+	var pairs []string
+	for _, host := range *h {
+		pairs = append(pairs, host.name+"="+host.root)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h *hostList) Set(value string) error {
+	name, root, ok := strings.Cut(value, "=")
+	if !ok || name == "" || root == "" {
+		return errors.New("expected name=dir")
+	}
+	for _, host := range *h {
+		if strings.EqualFold(host.name, name) {
+			return fmt.Errorf("%s given twice", name)
+		}
+	}
+	*h = append(*h, struct{ name, root string }{name, root})
+	return nil
+}
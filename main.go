@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"jtlweb/stuff/jtltp"
 	"jtlweb/stuff/processjtl"
 	"jtlweb/stuff/shared"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/veandco/go-sdl2/sdl"
 )
@@ -48,6 +54,19 @@ func main() {
 		sdl.Color{R: 255, G: 255, B: 255, A: 255},
 		sdl.Color{R: 100, G: 100, B: 100, A: 255})
 
+	// openLocation points the address field and relative paths at a page
+	openLocation := func(location string) {
+		textField.Text = location
+		openPath = location
+		shared.OpenPath = openPath
+		processjtl.Site = ""
+		if u, err := jtltp.ParseURL(location); err == nil {
+			processjtl.Site = jtltp.HostPort(u)
+		}
+		displayError = "" // Clear error on success
+	}
+	var load *pageLoad // remote page still arriving
+
 	running := true
 	for running {
 		if shared.OpenPath != openPath {
@@ -64,6 +83,13 @@ func main() {
 					w, h := processjtl.Window.GetSize()
 					textField.X = int32(w)/2 - textField.Width/2
 					textField.Y = int32(h)/2 - textField.Height/2
+					if state == StateRendering {
+						processjtl.Relayout()
+					}
+				}
+			case *sdl.TextInputEvent:
+				if state == StateInput {
+					textField.HandleTextInput(e)
 				}
 			case *sdl.MouseButtonEvent:
 				if state == StateInput {
@@ -72,6 +98,11 @@ func main() {
 			case *sdl.KeyboardEvent:
 				if e.Keysym.Sym == sdl.K_ESCAPE && state == StateRendering {
 					state = StateInput
+					processjtl.CancelFetches()
+					if load != nil {
+						load.cancel()
+						load = nil
+					}
 					textField.Text = ""
 					displayError = "" // Clear any previous error
 					// Reset window size and update TextField position
@@ -80,27 +111,36 @@ func main() {
 					textField.Y = int32(h)/2 - textField.Height/2
 				} else if state == StateInput {
 					if textField.HandleInput(e) {
-						// Handle file loading
-						content, err := os.ReadFile(textField.Text)
-						if err != nil {
-							fmt.Printf("Error reading file: %v\n", err)
-							displayError = fmt.Sprintf("Error reading file: %v", err)
+						if load != nil {
+							load.cancel()
+							load = nil
+						}
+
+						// remote pages stream in while the window keeps drawing
+						if jtltp.IsURL(textField.Text) {
+							var err error
+							load, err = startPageLoad(textField.Text)
+							if err != nil {
+								fmt.Println(err)
+								displayError = err.Error()
+								continue
+							}
+							displayError = "Loading..."
 							continue
 						}
 
-						textField.Text, err = getFullPath(textField.Text)
+						// Handle page loading
+						content, location, err := loadPage(textField.Text)
 						if err != nil {
-							fmt.Println("Error getting full path: ", err)
-							displayError = fmt.Sprintf("Error getting full path: %v", err)
+							fmt.Println(err)
+							displayError = err.Error()
 							continue
 						}
 
-						openPath = textField.Text
-						shared.OpenPath = openPath
-						displayError = "" // Clear error on success
+						openLocation(location)
 
 						// Clear error handling and debug output
-						winlock, objects = processjtl.MakeWebview(string(content))
+						winlock, objects = processjtl.MakeWebview(content)
 						if winlock != nil {
 						}
 						if objects == nil {
@@ -119,12 +159,66 @@ func main() {
 				if shared.OffY > 30 {
 					shared.OffY = 30
 				}
-				if shared.OffY < -1000 { // TODO: make this dynamic
-					shared.OffY = -1000
+				// stop once the bottom of the page is in view
+				_, h := processjtl.Window.GetSize()
+				bottom := min(int(h)-shared.ContentHeight, 0)
+				if shared.OffY < bottom {
+					shared.OffY = bottom
 				}
 			}
 		}
 
+		// feed the pieces of a loading page in as they arrive
+	drain:
+		for load != nil {
+			select {
+			case event := <-load.events:
+				if !event.done {
+					if load.page == nil {
+						openLocation(event.url)
+						load.page = processjtl.BeginWebview()
+						state = StateRendering
+					}
+					load.page.Feed(event.chunk)
+					continue
+				}
+
+				page, input := load.page, load.input
+				load = nil
+				if event.err != nil && page == nil {
+					fmt.Println(event.err)
+					if !errors.Is(event.err, jtltp.ErrCertificate) {
+						displayError = fmt.Sprintf("Error fetching page: %v", event.err)
+						break drain
+					}
+					// show a full error page, the reason doesn't fit under the address field
+					openLocation(input)
+					page = processjtl.BeginWebview()
+					page.Feed([]byte(errorPage("Certificate verification failed", event.err)))
+				} else if event.err != nil {
+					// keep what made it
+					fmt.Printf("Page stopped loading: %v\n", event.err)
+				}
+				if page == nil {
+					// an empty body never produced a piece
+					openLocation(event.url)
+					page = processjtl.BeginWebview()
+				}
+
+				winlock, objects = page.Finish()
+				if objects == nil {
+					displayError = "No objects created from JTL document"
+					fmt.Println(displayError)
+					state = StateInput
+					break drain
+				}
+				fmt.Printf("Created %d objects\n", len(objects))
+				state = StateRendering
+			default:
+				break drain
+			}
+		}
+
 		processjtl.Renderer.SetDrawColor(240, 240, 240, 255)
 		processjtl.Renderer.Clear()
 
@@ -160,6 +254,68 @@ func readConf() (string, error) {
 		return "", fmt.Errorf("error unmarshalling JSON: %v", err)
 	}
 
+	// optional jtltp client settings, timeouts in seconds
+	if seconds, ok := config["jtltpDialTimeout"].(float64); ok {
+		processjtl.FetchClient.DialTimeout = time.Duration(seconds * float64(time.Second))
+	}
+	if seconds, ok := config["jtltpReadTimeout"].(float64); ok {
+		processjtl.FetchClient.ReadTimeout = time.Duration(seconds * float64(time.Second))
+	}
+	if seconds, ok := config["jtltpWriteTimeout"].(float64); ok {
+		processjtl.FetchClient.WriteTimeout = time.Duration(seconds * float64(time.Second))
+	}
+	if retries, ok := config["jtltpRetries"].(float64); ok {
+		processjtl.FetchClient.Retries = int(retries)
+	}
+	if keepAlive, ok := config["jtltpKeepAlive"].(bool); ok {
+		processjtl.FetchClient.KeepAlive = keepAlive
+	}
+
+	// remote pages are cached in memory, and in jtltpCacheDir if set, up to
+	// jtltpCacheSize megabytes; a size of 0 turns the cache off
+	cacheSize := int64(64 << 20)
+	if megabytes, ok := config["jtltpCacheSize"].(float64); ok {
+		cacheSize = int64(megabytes * (1 << 20))
+	}
+	cacheDir, _ := config["jtltpCacheDir"].(string)
+	switch {
+	case cacheSize <= 0:
+		processjtl.FetchClient.Cache = nil
+	case cacheDir != "":
+		if !filepath.IsAbs(cacheDir) {
+			cacheDir = filepath.Join(exedir, cacheDir)
+		}
+		cache, err := jtltp.NewDiskCache(cacheSize, cacheDir)
+		if err != nil {
+			return "", fmt.Errorf("error creating jtltpCacheDir: %v", err)
+		}
+		processjtl.FetchClient.Cache = cache
+	default:
+		processjtl.FetchClient.Cache = jtltp.NewCache(cacheSize)
+	}
+
+	// jtltps:// trusts the system certificates plus an optional CA file, or
+	// anything at all when insecure (for self-signed certificates on localhost)
+	tlsConfig := &tls.Config{}
+	if caFile, ok := config["jtltpsCAFile"].(string); ok && caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading jtltpsCAFile: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificates found in jtltpsCAFile %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if insecure, ok := config["jtltpsInsecure"].(bool); ok {
+		tlsConfig.InsecureSkipVerify = insecure
+	}
+	processjtl.FetchClient.TLSConfig = tlsConfig
+
 	return filestring, nil
 }
 
@@ -168,7 +324,7 @@ func drawInputState(textField *processjtl.TextField) {
 	if shared.Debug {
 		textField.Text = "testingpage.jtl"
 	}
-	surface, err := processjtl.Fonts[config["defaultUrlTextboxFont"].(string)].RenderUTF8Blended("Enter JTL file path:",
+	surface, err := processjtl.Fonts[config["defaultUrlTextboxFont"].(string)].RenderUTF8Blended("Enter JTL file path or jtltp:// URL:",
 		sdl.Color{R: 0, G: 0, B: 0, A: 255})
 	if err == nil {
 		texture, err := processjtl.Renderer.CreateTextureFromSurface(surface)
@@ -221,6 +377,9 @@ func drawRenderingState(objects []processjtl.CanvasObject) {
 		return
 	}
 
+	// answers to document.fetch calls reach their lua callbacks here
+	processjtl.RunFetchCallbacks()
+
 	for _, obj := range localObjects {
 		if obj == nil {
 			continue
@@ -230,6 +389,87 @@ func drawRenderingState(objects []processjtl.CanvasObject) {
 	}
 }
 
+// pageLoad is a remote page arriving on a goroutine, the main loop takes its
+// pieces from events
+type pageLoad struct {
+	input  string // what was typed in the address field
+	events chan pageEvent
+	cancel context.CancelFunc
+	page   *processjtl.PageLoader // nil until the first piece arrived
+}
+
+type pageEvent struct {
+	url   string // where the page was found, after redirects
+	chunk []byte
+	done  bool
+	err   error
+}
+
+func startPageLoad(input string) (*pageLoad, error) {
+	u, err := jtltp.ParseURL(input)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing URL: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	load := &pageLoad{input: input, events: make(chan pageEvent, 64), cancel: cancel}
+	send := func(event pageEvent) {
+		select {
+		case load.events <- event:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		resp, err := processjtl.FetchClient.GetStream(ctx, u.String(), func(resp *jtltp.Response, chunk []byte) {
+			send(pageEvent{url: resp.URL, chunk: chunk})
+		})
+		event := pageEvent{url: u.String(), done: true, err: err}
+		if resp != nil && resp.URL != "" {
+			event.url = resp.URL
+		}
+		send(event)
+	}()
+	return load, nil
+}
+
+// loadPage reads a local JTL file and returns it along with its full path
+func loadPage(input string) (string, string, error) {
+	content, err := os.ReadFile(input)
+	if err != nil {
+		return "", "", fmt.Errorf("Error reading file: %v", err)
+	}
+
+	fullPath, err := getFullPath(input)
+	if err != nil {
+		return "", "", fmt.Errorf("Error getting full path: %v", err)
+	}
+	return string(content), fullPath, nil
+}
+
+// errorTemplate is the page shown in place of one that couldn't be loaded,
+// the error can hold anything so it goes through the template's escaping
+var errorTemplate = func() *jtltp.Template {
+	tmpl, err := jtltp.NewTemplate("error.jtl").Parse(">>>DOCTYPE=JTL\n\n" +
+		">>>BEGIN;\n" +
+		"    >id=\"error-title\">p>{{.Title}};\n" +
+		"    >id=\"error-message\">p>{{.Message}};\n" +
+		"    >id=\"error-hint\">p>Press escape to go back.;\n" +
+		">>>END;\n")
+	if err != nil {
+		panic(err)
+	}
+	return tmpl
+}()
+
+// errorPage builds a JTL document explaining why a page couldn't be shown
+func errorPage(title string, err error) string {
+	var page strings.Builder
+	if execErr := errorTemplate.Execute(&page, map[string]string{"Title": title, "Message": err.Error()}); execErr != nil {
+		return ">>>DOCTYPE=JTL\n\n>>>BEGIN;\n>>>END;\n"
+	}
+	return page.String()
+}
+
 func getFullPath(inputPath string) (string, error) {
 	// Expand the user's home directory (~) to its full path
 	expandedPath := inputPath
@@ -0,0 +1,111 @@
+package jtltp
+
+import (
+	"sync"
+)
+
+// subscriberBuffer is how many messages may wait for a slow subscriber
+// before it is dropped
+const subscriberBuffer = 64
+
+// Broadcaster is a Handler for subscriptions. Clients subscribe with
+// JTLTP-SUBSCRIBE (Client.Subscribe, document.subscribe in Lua) and get
+// every message passed to Broadcast until they leave or the server shuts
+// down. Use one Broadcaster per path:
+//
+//	scores := &jtltp.Broadcaster{Type: "txt"}
+//	mux.Handle("/live/scores", scores)
+//	scores.Broadcast([]byte("james=42"))
+type Broadcaster struct {
+	// Type is the JTLTP-TYPE of the messages, "txt" if empty.
+	Type string
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+// ServeJTLTP keeps a subscriber's connection open and writes each broadcast
+// message to it. Requests that aren't subscriptions get a 400.
+func (b *Broadcaster) ServeJTLTP(w ResponseWriter, r *Request) {
+	if r.Method != MethodSubscribe {
+		w.SendBad("Use JTLTP-SUBSCRIBE for this path", "txt")
+		return
+	}
+	if r.Header.Get("te") != "chunked" {
+		// messages are sent as chunks, a client that can't read them would
+		// never see one
+		w.SendBad("Subscriptions need te=[chunked]", "txt")
+		return
+	}
+
+	messages := b.subscribe()
+	defer b.unsubscribe(messages)
+
+	doctype := b.Type
+	if doctype == "" {
+		doctype = "txt"
+	}
+	stream := Stream(w, 200, doctype)
+	defer stream.Close()
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				// dropped for falling behind
+				return
+			}
+			if _, err := stream.Write(message); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Broadcast sends message to every current subscriber and returns how many
+// there were. Subscribers too slow to keep up are disconnected. Empty
+// messages are not sent.
+func (b *Broadcaster) Broadcast(message []byte) int {
+	if len(message) == 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sent := 0
+	for messages := range b.subscribers {
+		select {
+		case messages <- message:
+			sent++
+		default:
+			delete(b.subscribers, messages)
+			close(messages)
+		}
+	}
+	return sent
+}
+
+// Subscribers returns how many clients are subscribed.
+func (b *Broadcaster) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+func (b *Broadcaster) subscribe() chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan []byte]struct{})
+	}
+	messages := make(chan []byte, subscriberBuffer)
+	b.subscribers[messages] = struct{}{}
+	return messages
+}
+
+func (b *Broadcaster) unsubscribe(messages chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, messages)
+}
@@ -0,0 +1,129 @@
+package jtltp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	scores := &Broadcaster{}
+	mux := NewServeMux()
+	mux.Handle("/live/scores", scores)
+	mux.HandleFunc("/moved", func(w ResponseWriter, r *Request) { Redirect(w, "/live/scores", 302) })
+	serverCtx, shutdown := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- (&Server{Handler: mux}).Serve(serverCtx, listener) }()
+	url := "jtltp://" + listener.Addr().String()
+
+	type subscriber struct {
+		messages chan string
+		result   chan error
+		cancel   context.CancelFunc
+	}
+	subscribe := func(path string) *subscriber {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := &subscriber{messages: make(chan string, 10), result: make(chan error, 1), cancel: cancel}
+		go func() {
+			s.result <- (&Client{ReadTimeout: 100 * time.Millisecond}).Subscribe(ctx, url+path, func(message []byte) {
+				s.messages <- string(message)
+			})
+		}()
+		return s
+	}
+	first := subscribe("/live/scores")
+	second := subscribe("/moved")
+	waitFor(t, "two subscribers", func() bool { return scores.Subscribers() == 2 })
+
+	// messages far apart don't trip the client's read timeout
+	time.Sleep(200 * time.Millisecond)
+	if n := scores.Broadcast([]byte("james=42")); n != 2 {
+		t.Errorf("Broadcast reached %d subscribers, want 2", n)
+	}
+	scores.Broadcast([]byte("orthe=43"))
+	for _, s := range []*subscriber{first, second} {
+		for _, want := range []string{"james=42", "orthe=43"} {
+			select {
+			case got := <-s.messages:
+				if got != want {
+					t.Errorf("Got message %q, want %q", got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Message %q never arrived", want)
+			}
+		}
+	}
+
+	// a subscriber leaving is noticed by the server
+	first.cancel()
+	if err := <-first.result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	waitFor(t, "the subscriber to leave", func() bool { return scores.Subscribers() == 1 })
+
+	// shutting down ends the remaining subscription cleanly
+	shutdown()
+	if err := <-second.result; err != nil {
+		t.Errorf("Expected the subscription to end without an error, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+	second.cancel()
+}
+
+func TestBroadcasterEndsWithoutMessages(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	scores := &Broadcaster{}
+	serverCtx, shutdown := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- (&Server{Handler: scores}).Serve(serverCtx, listener) }()
+
+	var messages []string
+	result := make(chan error, 1)
+	go func() {
+		result <- (&Client{}).Subscribe(context.Background(), "jtltp://"+listener.Addr().String()+"/", func(message []byte) {
+			messages = append(messages, string(message))
+		})
+	}()
+	waitFor(t, "the subscriber", func() bool { return scores.Subscribers() == 1 })
+
+	shutdown()
+	if err := <-result; err != nil {
+		t.Errorf("Expected the subscription to end without an error, got %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("Expected no messages, got %q", messages)
+	}
+	<-done
+}
+
+func TestBroadcasterNeedsSubscribe(t *testing.T) {
+	address := startServer(t, &Server{Handler: &Broadcaster{}})
+	resp, err := (&Client{}).Fetch(context.Background(), address, "/")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || resp.Status != 400 {
+		t.Errorf("Expected a 400 for a GET, got %v", err)
+	}
+}
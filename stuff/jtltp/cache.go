@@ -0,0 +1,416 @@
+package jtltp
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields only found in cache files, next to those of the stored response.
+const (
+	fieldCacheKey    = "JTLTP-CACHE-KEY"
+	fieldCacheStored = "JTLTP-CACHE-STORED"
+)
+
+// Cache keeps GET responses for a Client. It follows the cache-control header
+// of each response:
+//
+//	cache-control=[max-age=60]   reuse for 60 seconds without asking
+//	cache-control=[no-cache]     keep, but revalidate before every use
+//	cache-control=[no-store]     never keep
+//
+// A stale response with an etag or last-modified header is revalidated with
+// if-none-match and if-modified-since; a 304 answer means the stored copy is
+// still good. Responses with neither max-age nor a validator aren't kept.
+//
+// Memory, and the optional directory, each hold at most maxBytes of
+// responses, the least recently used are evicted first. A Cache is safe to
+// share between clients.
+type Cache struct {
+	maxBytes int64
+	dir      string
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	resp   *Response
+	stored time.Time
+}
+
+// NewCache returns an in-memory cache holding up to maxBytes.
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// NewDiskCache returns a cache that also keeps responses in dir, so they
+// survive restarts. dir is created if needed and holds up to maxBytes too.
+func NewDiskCache(maxBytes int64, dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := NewCache(maxBytes)
+	c.dir = dir
+	return c, nil
+}
+
+// Clear drops every stored response, from memory and from disk.
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+
+	if c.dir == "" {
+		return nil
+	}
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if isCacheFile(file.Name()) {
+			os.Remove(filepath.Join(c.dir, file.Name()))
+		}
+	}
+	return nil
+}
+
+// cachedSend is send for GET requests when the client has a Cache
+func (c *Client) cachedSend(ctx context.Context, address string, tlsConfig *tls.Config, req *Request) (*Response, error) {
+	key := poolKey(address, tlsConfig) + " " + req.Path
+	if host := req.Header.Get("host"); host != "" {
+		// another site behind the same address
+		key += " " + cleanHost(host)
+	}
+	entry := c.Cache.get(key)
+	if entry != nil {
+		if entry.fresh(time.Now()) {
+			resp := entry.response()
+			req.deliver(resp)
+			return resp, nil
+		}
+		if conditional, ok := entry.conditional(req); ok {
+			req = conditional
+		}
+	}
+
+	resp, err := c.transmit(ctx, address, tlsConfig, req)
+	if entry != nil && resp != nil && resp.Status == 304 {
+		resp = c.Cache.revalidated(entry, resp).response()
+		req.deliver(resp)
+		return resp, nil
+	}
+	if err == nil {
+		c.Cache.put(key, resp, time.Now())
+	}
+	return resp, err
+}
+
+// cacheControl reads the directives of a cache-control header
+func cacheControl(h Header) (maxAge time.Duration, hasMaxAge bool, noCache bool, noStore bool) {
+	for _, directive := range strings.Split(h.Get("cache-control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache":
+			noCache = true
+		case directive == "no-store":
+			noStore = true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				maxAge, hasMaxAge = time.Duration(seconds)*time.Second, true
+			}
+		}
+	}
+	return maxAge, hasMaxAge, noCache, noStore
+}
+
+func cacheable(resp *Response) bool {
+	if resp.Status != 200 {
+		return false
+	}
+	_, hasMaxAge, _, noStore := cacheControl(resp.Header)
+	if noStore {
+		return false
+	}
+	return hasMaxAge || resp.Header.Get("etag") != "" || resp.Header.Get("last-modified") != ""
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	maxAge, hasMaxAge, noCache, _ := cacheControl(e.resp.Header)
+	return hasMaxAge && !noCache && now.Sub(e.stored) < maxAge
+}
+
+// conditional returns a copy of req asking the server to answer 304 if the
+// stored response is still current
+func (e *cacheEntry) conditional(req *Request) (*Request, bool) {
+	etag := e.resp.Header.Get("etag")
+	modified := e.resp.Header.Get("last-modified")
+	if etag == "" && modified == "" {
+		return nil, false
+	}
+
+	conditional := *req
+	conditional.Header = Header{}
+	for key, value := range req.Header {
+		conditional.Header[key] = value
+	}
+	if etag != "" {
+		conditional.Header.Set("if-none-match", etag)
+	}
+	if modified != "" {
+		conditional.Header.Set("if-modified-since", modified)
+	}
+	return &conditional, true
+}
+
+// response returns a copy of the stored response for the caller to keep
+func (e *cacheEntry) response() *Response {
+	resp := *e.resp
+	resp.Header = Header{}
+	for key, value := range e.resp.Header {
+		resp.Header[key] = value
+	}
+	resp.Body = append([]byte(nil), e.resp.Body...)
+	return &resp
+}
+
+func (e *cacheEntry) size() int64 {
+	n := len(e.key) + len(e.resp.Type) + len(e.resp.Body)
+	for key, value := range e.resp.Header {
+		n += len(key) + len(value)
+	}
+	return int64(n)
+}
+
+// get finds key in memory, then on disk
+func (c *Cache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*cacheEntry)
+	}
+
+	entry := c.readFile(key)
+	if entry != nil {
+		c.add(entry)
+	}
+	return entry
+}
+
+func (c *Cache) put(key string, resp *Response, now time.Time) {
+	if !cacheable(resp) {
+		c.remove(key)
+		return
+	}
+
+	stored := *resp
+	stored.Header = Header{}
+	for name, value := range resp.Header {
+		stored.Header[name] = value
+	}
+	// the connection belongs to this response only
+	stored.Header.Del("connection")
+	stored.Header.Del("transfer-encoding")
+	stored.Body = append([]byte(nil), resp.Body...)
+	entry := &cacheEntry{key: key, resp: &stored, stored: now}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(entry)
+	c.writeFile(entry)
+}
+
+// revalidated updates entry after a 304 with the headers the server sent
+// along, and restarts its max-age
+func (c *Cache) revalidated(entry *cacheEntry, notModified *Response) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := *entry
+	resp := *entry.resp
+	resp.Header = Header{}
+	for key, value := range entry.resp.Header {
+		resp.Header[key] = value
+	}
+	for _, key := range []string{"cache-control", "etag", "last-modified"} {
+		if value := notModified.Header.Get(key); value != "" {
+			resp.Header.Set(key, value)
+		}
+	}
+	updated.resp = &resp
+	updated.stored = time.Now()
+
+	c.add(&updated)
+	c.writeFile(&updated)
+	return &updated
+}
+
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*cacheEntry).size()
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+	if c.dir != "" {
+		os.Remove(c.filename(key))
+	}
+}
+
+// add stores entry in memory, replacing an older one for the same key, and
+// evicts the least recently used entries until everything fits. c.mu must be
+// held.
+func (c *Cache) add(entry *cacheEntry) {
+	if elem, ok := c.entries[entry.key]; ok {
+		c.size -= elem.Value.(*cacheEntry).size()
+		c.lru.Remove(elem)
+		delete(c.entries, entry.key)
+	}
+	if entry.size() > c.maxBytes {
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size()
+	for c.size > c.maxBytes {
+		oldest := c.lru.Back()
+		evicted := oldest.Value.(*cacheEntry)
+		c.size -= evicted.size()
+		c.lru.Remove(oldest)
+		delete(c.entries, evicted.key)
+	}
+}
+
+// cache files are named after the sha256 of their key
+func (c *Cache) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func isCacheFile(name string) bool {
+	_, err := hex.DecodeString(name)
+	return err == nil && len(name) == sha256.Size*2
+}
+
+// readFile loads key from disk, nil if there's no cache directory or no
+// usable file for it
+func (c *Cache) readFile(key string) *cacheEntry {
+	if c.dir == "" {
+		return nil
+	}
+	name := c.filename(key)
+	file, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	f, err := readFrame(bufio.NewReader(file))
+	if err != nil {
+		return nil
+	}
+	storedKey, _ := f.get(fieldCacheKey)
+	storedUnix, _ := f.get(fieldCacheStored)
+	stored, err := strconv.ParseInt(storedUnix, 10, 64)
+	if storedKey != key || err != nil {
+		return nil
+	}
+	resp, err := responseFromFrame(f)
+	if err != nil {
+		return nil
+	}
+
+	// the modification time orders files for eviction
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return &cacheEntry{key: key, resp: resp, stored: time.Unix(0, stored)}
+}
+
+// writeFile saves entry to disk and trims the directory to maxBytes. Errors
+// only cost a cache miss later, so they are ignored.
+func (c *Cache) writeFile(entry *cacheEntry) {
+	if c.dir == "" || entry.size() > c.maxBytes {
+		return
+	}
+
+	f := entry.resp.toFrame()
+	f.fields = append([]field{
+		{fieldCacheKey, entry.key},
+		{fieldCacheStored, strconv.FormatInt(entry.stored.UnixNano(), 10)},
+	}, f.fields...)
+
+	tmp, err := os.CreateTemp(c.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	err = writeFrame(tmp, f)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.filename(entry.key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	c.trimDir()
+}
+
+// trimDir removes the least recently used files until the directory fits in
+// maxBytes
+func (c *Cache) trimDir() {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, dirEntry := range dirEntries {
+		if !isCacheFile(dirEntry.Name()) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	if total <= c.maxBytes {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, info.Name())) == nil {
+			total -= info.Size()
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package jtltp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer answers every path with its body and cache-control header,
+// counting the requests that reach it
+func countingServer(t *testing.T, cacheControl string) (string, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	address := startServer(t, &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		n := hits.Add(1)
+		w.Header().Set("cache-control", cacheControl)
+		w.SendGood("answer "+strconv.Itoa(int(n))+" for "+r.Path, "txt")
+	})})
+	return address, &hits
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		wantHits     int32
+	}{
+		{"max-age=60", 1},
+		{"no-store", 3},
+		{"no-cache, max-age=60", 3},
+		{"max-age=0", 3},
+		{"", 3},
+	}
+	for _, tt := range tests {
+		address, hits := countingServer(t, tt.cacheControl)
+		client := &Client{Cache: NewCache(1 << 20)}
+		for i := 0; i < 3; i++ {
+			resp, err := client.Fetch(context.Background(), address, "/page.jtl")
+			if err != nil {
+				t.Fatalf("%q: fetch failed: %v", tt.cacheControl, err)
+			}
+			if i == 0 && string(resp.Body) != "answer 1 for /page.jtl" {
+				t.Errorf("%q: unexpected body %q", tt.cacheControl, resp.Body)
+			}
+		}
+		if hits.Load() != tt.wantHits {
+			t.Errorf("%q: server got %d requests, want %d", tt.cacheControl, hits.Load(), tt.wantHits)
+		}
+	}
+
+	// only GET is cached
+	address, hits := countingServer(t, "max-age=60")
+	client := &Client{Cache: NewCache(1 << 20)}
+	for i := 0; i < 2; i++ {
+		client.Do(context.Background(), address, NewRequest(MethodPost, "/page.jtl", []byte("x")))
+	}
+	if hits.Load() != 2 {
+		t.Errorf("POST was answered from the cache")
+	}
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	root := t.TempDir()
+	page := filepath.Join(root, "index.jtl")
+	os.WriteFile(page, []byte("version one"), 0o644)
+
+	var hits, notModified atomic.Int32
+	files := FileServer(root)
+	address := startServer(t, &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		hits.Add(1)
+		if r.Header.Get("if-none-match") != "" {
+			notModified.Add(1)
+		}
+		files.ServeJTLTP(w, r)
+	})})
+
+	client := &Client{Cache: NewCache(1 << 20)}
+	fetch := func() string {
+		t.Helper()
+		resp, err := client.Fetch(context.Background(), address, "/")
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		return string(resp.Body)
+	}
+
+	first := fetch()
+	if second := fetch(); first != "version one" || second != "version one" {
+		t.Errorf("Unexpected bodies %q, %q", first, second)
+	}
+	if hits.Load() != 2 || notModified.Load() != 1 {
+		t.Errorf("Expected one full fetch and one revalidation, got %d requests, %d conditional", hits.Load(), notModified.Load())
+	}
+
+	// a changed file gets a new etag and is sent in full
+	os.WriteFile(page, []byte("version two"), 0o644)
+	os.Chtimes(page, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if got := fetch(); got != "version two" {
+		t.Errorf("Expected the new version, got %q", got)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	address, hits := countingServer(t, "max-age=60")
+	// room for about two responses
+	client := &Client{Cache: NewCache(120)}
+	for _, path := range []string{"/a", "/b", "/a", "/c", "/a", "/b"} {
+		if _, err := client.Fetch(context.Background(), address, path); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+	}
+	// /a stays as the most recently used, /b is evicted by /c
+	if hits.Load() != 4 {
+		t.Errorf("Expected 4 requests to reach the server, got %d", hits.Load())
+	}
+	if client.Cache.size > 120 {
+		t.Errorf("Cache grew to %d bytes", client.Cache.size)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	address, hits := countingServer(t, "max-age=60")
+
+	cache, err := NewDiskCache(1<<20, dir)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	if _, err := (&Client{Cache: cache}).Fetch(context.Background(), address, "/page.jtl"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	// a new cache on the same directory, like after a restart
+	reopened, err := NewDiskCache(1<<20, dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	resp, err := (&Client{Cache: reopened}).Fetch(context.Background(), address, "/page.jtl")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if hits.Load() != 1 || string(resp.Body) != "answer 1 for /page.jtl" {
+		t.Errorf("Expected the stored answer, got %q after %d requests", resp.Body, hits.Load())
+	}
+
+	if err := reopened.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Clear left %d files", len(files))
+	}
+}
@@ -0,0 +1,471 @@
+package jtltp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Errors returned by Client. They wrap the underlying network error, so both
+// errors.Is(err, ErrTimeout) and checks against the original error work.
+var (
+	ErrConnectionRefused = errors.New("jtltp: connection refused")
+	ErrTimeout           = errors.New("jtltp: timeout")
+	ErrMalformedResponse = errors.New("jtltp: malformed response")
+	ErrTooManyRedirects  = errors.New("jtltp: too many redirects")
+	ErrRedirectLoop      = errors.New("jtltp: redirect loop")
+	ErrCertificate       = errors.New("jtltp: certificate verification failed")
+)
+
+// StatusError is returned along with the response when a server answers with
+// anything but 200.
+type StatusError struct {
+	Status   int
+	Response *Response
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("jtltp: status %d: %s", e.Status, e.Response.Body)
+}
+
+// Client fetches documents from JTLTP servers. The zero value uses the
+// default timeouts and doesn't retry.
+type Client struct {
+	DialTimeout  time.Duration // zero means 10 seconds
+	ReadTimeout  time.Duration // zero means 30 seconds
+	WriteTimeout time.Duration // zero means 10 seconds
+
+	// Retries is how many more times to try after a refused connection or a
+	// timeout. Other failures are returned right away, and a POST or PUT is
+	// only tried again if it never reached the server.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for each one after
+	// that. Zero means 200 milliseconds.
+	Backoff time.Duration
+
+	// MaxRedirects is how many redirects Get and DoURL follow before giving
+	// up. Zero means 10, negative means redirects are returned as is.
+	MaxRedirects int
+
+	// TLSConfig is used for jtltps:// URLs. Nil means the system's trusted
+	// certificates; set RootCAs to trust others, or InsecureSkipVerify to
+	// trust anything.
+	TLSConfig *tls.Config
+
+	// KeepAlive asks servers to keep the connection open after a response,
+	// so later requests to the same host reuse it. Servers that don't agree
+	// close it as usual.
+	KeepAlive bool
+	// IdleTimeout is how long an unused connection stays in the pool. Zero
+	// means 90 seconds.
+	IdleTimeout time.Duration
+	// MaxIdlePerHost caps the pooled connections per host. Zero means 2.
+	MaxIdlePerHost int
+
+	// DisableCompression stops the client from sending accept-encoding.
+	// Otherwise gzip and deflate bodies are decompressed transparently, unless
+	// the request set its own accept-encoding.
+	DisableCompression bool
+
+	// Cache, if set, keeps GET responses and answers from it while they are
+	// fresh, see Cache.
+	Cache *Cache
+
+	// Dump, if set, gets a copy of everything sent and received, after TLS,
+	// with lines starting "> " for what was sent and "< " for what came back.
+	// For debugging.
+	Dump io.Writer
+
+	mu       sync.Mutex
+	idle     map[string][]*clientConn
+	versions map[string]int // the protocol each server answered in, 0 until known
+	dumper   *dumper
+}
+
+// DefaultClient is used by JtltpFetch.
+var DefaultClient = &Client{Cache: NewCache(32 << 20)}
+
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// Fetch asks the server at address for what. A non-200 answer gives both the
+// response and a *StatusError.
+func (c *Client) Fetch(ctx context.Context, address string, what string) (*Response, error) {
+	return c.Do(ctx, address, NewRequest(MethodGet, what, nil))
+}
+
+// Do sends req to the server at address over plain TCP, see Fetch for the
+// result. Use DoURL for jtltps:// servers.
+func (c *Client) Do(ctx context.Context, address string, req *Request) (*Response, error) {
+	return c.send(ctx, address, nil, req)
+}
+
+// send is Do with TLS when tlsConfig is set
+func (c *Client) send(ctx context.Context, address string, tlsConfig *tls.Config, req *Request) (*Response, error) {
+	if c.Cache != nil && (req.Method == MethodGet || req.Method == "") {
+		return c.cachedSend(ctx, address, tlsConfig, req)
+	}
+	return c.transmit(ctx, address, tlsConfig, req)
+}
+
+// transmit sends req over the network, retrying if the client says so
+func (c *Client) transmit(ctx context.Context, address string, tlsConfig *tls.Config, req *Request) (*Response, error) {
+	request, err := req.toFrame()
+	if err != nil {
+		return nil, err
+	}
+	if c.KeepAlive {
+		request.set("connection", "keep-alive")
+	}
+	decompressBody := !c.DisableCompression && req.Header.Get("accept-encoding") == ""
+	if decompressBody {
+		request.set("accept-encoding", acceptEncoding)
+	}
+	// the client can always read chunked bodies
+	request.set("te", "chunked")
+	// the name we connected to, for servers hosting several sites
+	if req.Header.Get("host") == "" {
+		request.set("host", cleanHost(address))
+	}
+
+	// once part of a body went to the caller, a retry would repeat it
+	delivered := false
+	var onChunk func(resp *Response, chunk []byte)
+	if req.onChunk != nil {
+		onChunk = func(resp *Response, chunk []byte) {
+			delivered = true
+			resp.URL = req.url
+			req.onChunk(resp, chunk)
+		}
+	}
+
+	backoff := durationOr(c.Backoff, 200*time.Millisecond)
+	for attempt := 0; ; attempt++ {
+		resp, err := c.doOnce(ctx, address, tlsConfig, request, onChunk)
+		if resp != nil && decompressBody {
+			if decompressErr := decompress(resp); decompressErr != nil {
+				return nil, decompressErr
+			}
+		}
+		if resp != nil && !delivered {
+			req.deliver(resp)
+		}
+		if err == nil || delivered || attempt >= c.Retries || !retryable(req.Method, err) {
+			return resp, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// Get fetches a jtltp:// URL, following redirects. Response.URL is where the
+// document was finally found.
+func (c *Client) Get(ctx context.Context, rawurl string) (*Response, error) {
+	return c.DoURL(ctx, rawurl, NewRequest(MethodGet, "", nil))
+}
+
+// DoURL sends req to the server named by rawurl, with the URL's path in place
+// of req.Path, and follows redirects.
+func (c *Client) DoURL(ctx context.Context, rawurl string, req *Request) (*Response, error) {
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+
+	u, err := ParseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	visited := map[string]bool{}
+	for redirects := 0; ; redirects++ {
+		visited[u.String()] = true
+
+		next := *req
+		next.Path = RequestPath(u)
+		next.url = u.String()
+		resp, err := c.send(ctx, HostPort(u), c.tlsConfigFor(u), &next)
+		if resp != nil {
+			resp.URL = u.String()
+		}
+		if resp == nil || !IsRedirect(resp.Status) || maxRedirects < 0 {
+			return resp, err
+		}
+
+		location := resp.Header.Get("location")
+		if location == "" {
+			return resp, err
+		}
+		if redirects >= maxRedirects {
+			return resp, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, redirects)
+		}
+		ref, err := url.Parse(location)
+		if err != nil {
+			return resp, fmt.Errorf("%w: bad location %q", ErrMalformedResponse, location)
+		}
+		u, err = ParseURL(u.ResolveReference(ref).String())
+		if err != nil {
+			return resp, err
+		}
+		if visited[u.String()] {
+			return resp, fmt.Errorf("%w: %s", ErrRedirectLoop, u)
+		}
+
+		// like net/http, 301 to 303 turn into a plain GET, 307 and 308 are
+		// repeated as they were. subscriptions stay subscriptions
+		if resp.Status <= 303 && req.Method != MethodSubscribe {
+			req = NewRequest(MethodGet, "", nil)
+			req.Header = next.Header
+		}
+	}
+}
+
+// retryable says if a request that failed with err can be sent again. A POST
+// or PUT that reached the server may already have done its work, those are
+// only repeated when they never went out.
+func retryable(method string, err error) bool {
+	if !errors.Is(err, ErrConnectionRefused) && !errors.Is(err, ErrTimeout) {
+		return false
+	}
+	if method == MethodPost || method == MethodPut {
+		var notSent notSentError
+		return errors.As(err, &notSent)
+	}
+	return true
+}
+
+// notSentError marks a failure from before the request was written, like a
+// dial that was refused or timed out
+type notSentError struct{ err error }
+
+func (e notSentError) Error() string { return e.err.Error() }
+func (e notSentError) Unwrap() error { return e.err }
+
+// unansweredError marks a request that was sent but got nothing back before
+// the connection timed out or closed
+type unansweredError struct{ err error }
+
+func (e unansweredError) Error() string { return e.err.Error() }
+func (e unansweredError) Unwrap() error { return e.err }
+
+// tlsConfigFor returns the TLS settings for a jtltps:// URL, nil for jtltp://
+func (c *Client) tlsConfigFor(u *url.URL) *tls.Config {
+	if !IsTLS(u) {
+		return nil
+	}
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	return config
+}
+
+func (c *Client) doOnce(ctx context.Context, address string, tlsConfig *tls.Config, request *frame, onChunk func(*Response, []byte)) (*Response, error) {
+	key := poolKey(address, tlsConfig)
+	what, v1 := legacyPath(request)
+	if v1 && c.version(key) == 1 {
+		return c.doLegacy(ctx, address, tlsConfig, key, what)
+	}
+	if c.KeepAlive {
+		if cc := c.getIdle(key); cc != nil {
+			resp, err := c.roundTrip(ctx, cc, request, onChunk)
+			if !errors.Is(err, errStaleConn) {
+				return resp, err
+			}
+			// the server dropped the idle connection, try again on a new one
+		}
+	}
+
+	cc, err := c.dial(ctx, address, tlsConfig, key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(ctx, cc, request, onChunk)
+	var unanswered unansweredError
+	if v1 && errors.As(err, &unanswered) && c.version(key) == 0 {
+		// version 1 servers ignore a framed request, or hang up on it. if
+		// one answers the old way, talk to it that way from now on,
+		// otherwise it's only slow and isn't asked twice again
+		legacyResp, legacyErr := c.doLegacy(ctx, address, tlsConfig, key, what)
+		if legacyResp != nil {
+			c.setVersion(key, 1)
+			return legacyResp, legacyErr
+		}
+		c.setVersion(key, Version)
+	}
+	return resp, err
+}
+
+// doLegacy sends what as a version 1 request, JTLTP-GET=[what]
+func (c *Client) doLegacy(ctx context.Context, address string, tlsConfig *tls.Config, key, what string) (*Response, error) {
+	cc, err := c.dial(ctx, address, tlsConfig, key)
+	if err != nil {
+		return nil, err
+	}
+	return c.roundTrip(ctx, cc, &frame{fields: []field{{fieldGet, what}}, legacy: true}, nil)
+}
+
+// dial opens a new connection to address
+func (c *Client) dial(ctx context.Context, address string, tlsConfig *tls.Config, key string) (*clientConn, error) {
+	dialTimeout := durationOr(c.DialTimeout, 10*time.Second)
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		// the handshake counts towards the dial timeout
+		dialer := tls.Dialer{NetDialer: &net.Dialer{Timeout: dialTimeout}, Config: tlsConfig}
+		dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		conn, err = dialer.DialContext(dialCtx, "tcp", address)
+		cancel()
+	} else {
+		dialer := net.Dialer{Timeout: dialTimeout}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, notSentError{classify(ctx, err)}
+	}
+	if c.Dump != nil {
+		conn = &dumpConn{Conn: conn, dump: c.getDumper()}
+	}
+	return &clientConn{conn: conn, reader: bufio.NewReader(conn), key: key}, nil
+}
+
+// legacyPath returns the path of a request version 1 can carry, a GET
+// without a body
+func legacyPath(request *frame) (string, bool) {
+	what, ok := request.get(fieldGet)
+	return what, ok && len(request.body) == 0 && what != "" && !strings.Contains(what, "]")
+}
+
+func (c *Client) version(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.versions[key]
+}
+
+func (c *Client) setVersion(key string, version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.versions == nil {
+		c.versions = make(map[string]int)
+	}
+	c.versions[key] = version
+}
+
+// roundTrip sends request on cc and reads the response, handing the pieces of
+// a chunked 200 body to onChunk. cc goes back to the pool if both sides
+// agreed to keep it alive, otherwise it is closed.
+func (c *Client) roundTrip(ctx context.Context, cc *clientConn, request *frame, onChunk func(*Response, []byte)) (*Response, error) {
+	keep := false
+	defer func() {
+		if !keep {
+			cc.conn.Close()
+		}
+	}()
+
+	// unblock reads and writes as soon as ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { cc.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	cc.conn.SetWriteDeadline(time.Now().Add(durationOr(c.WriteTimeout, 10*time.Second)))
+	var err error
+	if request.legacy {
+		err = writeLegacyRequest(cc.conn, request)
+	} else {
+		err = writeFrame(cc.conn, request)
+	}
+	if err != nil {
+		return nil, cc.failed(ctx, err)
+	}
+
+	// read the response, old servers answer without a version marker
+	readTimeout := durationOr(c.ReadTimeout, 30*time.Second)
+	cc.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	framed, err := isFramed(cc.reader)
+	if err != nil {
+		if err = cc.failed(ctx, err); ctx.Err() == nil && !errors.Is(err, errStaleConn) {
+			err = unansweredError{err}
+		}
+		return nil, err
+	}
+
+	var message *frame
+	if framed {
+		c.setVersion(cc.key, Version)
+		message, err = readFrame(cc.reader)
+	} else {
+		message, err = readLegacyResponse(cc.reader)
+	}
+	if err != nil {
+		return nil, classify(ctx, err)
+	}
+
+	resp, err := responseFromFrame(message)
+	if err != nil {
+		return nil, err
+	}
+	if framed && resp.Header.Get("transfer-encoding") == "chunked" {
+		// encoded pieces are only usable once the whole body is decompressed
+		if resp.Status != 200 || resp.Header.Get("content-encoding") != "" {
+			onChunk = nil
+		}
+		_, subscription := request.get("JTLTP-" + MethodSubscribe)
+		if err := readChunks(ctx, cc.conn, cc.reader, readTimeout, resp, onChunk, subscription); err != nil {
+			return nil, err
+		}
+	}
+
+	// a cancelled ctx may already have broken the deadlines, stop says so
+	if c.KeepAlive && framed && resp.Header.Get("connection") == "keep-alive" && stop() {
+		cc.conn.SetDeadline(time.Time{})
+		keep = c.putIdle(cc)
+	}
+
+	if resp.Status != 200 {
+		return resp, &StatusError{Status: resp.Status, Response: resp}
+	}
+	return resp, nil
+}
+
+// classify wraps a network or parse error in the matching Err value.
+func classify(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &certErr):
+		return fmt.Errorf("%w: %w", ErrCertificate, err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("%w: %w", ErrConnectionRefused, err)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case errors.Is(err, errMalformed), errors.Is(err, errVersion), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+	return err
+}
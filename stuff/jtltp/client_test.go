@@ -0,0 +1,261 @@
+package jtltp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// freeAddress returns a loopback address nothing is listening on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+// rawServer answers one connection with whatever reply writes.
+func rawServer(t *testing.T, reply func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reply(conn)
+	}()
+	return listener.Addr().String()
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		address func(t *testing.T) string
+		want    error
+	}{
+		{"refused", freeAddress, ErrConnectionRefused},
+		{"timeout", func(t *testing.T) string {
+			return rawServer(t, func(conn net.Conn) { time.Sleep(time.Second) })
+		}, ErrTimeout},
+		{"garbage", func(t *testing.T) string {
+			return rawServer(t, func(conn net.Conn) { conn.Write([]byte("JTLTP-VERSION=[2] nonsense\n")) })
+		}, ErrMalformedResponse},
+		{"short body", func(t *testing.T) string {
+			return rawServer(t, func(conn net.Conn) {
+				conn.Write([]byte("JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP-LENGTH=[100]\nshort"))
+			})
+		}, ErrMalformedResponse},
+		{"hang up", func(t *testing.T) string {
+			return rawServer(t, func(conn net.Conn) {})
+		}, ErrMalformedResponse},
+	}
+
+	client := &Client{ReadTimeout: 100 * time.Millisecond}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Fetch(context.Background(), tt.address(t), "/index.jtl")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+			if resp != nil {
+				t.Errorf("Expected no response, got %v", resp)
+			}
+		})
+	}
+}
+
+func TestClientStatusError(t *testing.T) {
+	address := startServer(t, &Server{Handler: NotFoundHandler()})
+
+	resp, err := (&Client{}).Fetch(context.Background(), address, "/missing.jtl")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != 404 {
+		t.Fatalf("Expected a 404 StatusError, got %v", err)
+	}
+	if resp == nil || resp.Status != 404 || statusErr.Response != resp {
+		t.Errorf("Expected the response alongside the error, got %v", resp)
+	}
+
+	// JtltpFetch keeps treating it as a normal answer
+	result, err := JtltpFetch(address, "/missing.jtl")
+	if err != nil || result["JTLTP-STATUS"] != "404" {
+		t.Errorf("JtltpFetch: got %v, %v", result, err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	address := freeAddress(t)
+
+	// the server comes up only after the first attempt was refused
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			t.Errorf("Failed to start late server: %v", err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) { w.SendGood("finally", "txt") })}
+		server.Serve(ctx, listener)
+	}()
+
+	client := &Client{Retries: 5, Backoff: 50 * time.Millisecond}
+	resp, err := client.Fetch(context.Background(), address, "/")
+	if err != nil {
+		t.Fatalf("Fetch with retries failed: %v", err)
+	}
+	if string(resp.Body) != "finally" {
+		t.Errorf("Unexpected body: %v", resp)
+	}
+
+	noRetry := &Client{}
+	if _, err := noRetry.Fetch(context.Background(), freeAddress(t), "/"); !errors.Is(err, ErrConnectionRefused) {
+		t.Errorf("Expected a refused connection without retries, got %v", err)
+	}
+}
+
+func TestClientRetryOnlyUnsent(t *testing.T) {
+	var hits atomic.Int32
+	address := startServer(t, &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Path == "/quick" {
+			w.SendGood("quick", "txt")
+			return
+		}
+		hits.Add(1)
+		time.Sleep(300 * time.Millisecond)
+		w.SendGood("late", "txt")
+	})})
+
+	// the POST reached the server before timing out, sending it again would
+	// run the handler twice
+	client := &Client{Retries: 2, Backoff: 10 * time.Millisecond, ReadTimeout: 100 * time.Millisecond}
+	if _, err := client.Do(context.Background(), address, NewRequest(MethodPost, "/scores", []byte("a=1"))); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("Expected the POST to run once, ran %d times", n)
+	}
+
+	// once the server answered framed it isn't asked the version 1 way
+	if _, err := client.Fetch(context.Background(), address, "/quick"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	hits.Store(0)
+	if _, err := client.Fetch(context.Background(), address, "/"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("Expected the GET to be tried 3 times, ran %d times", n)
+	}
+
+	// a refused POST never went out and can be sent again
+	refused := &Client{Retries: 2, Backoff: 10 * time.Millisecond}
+	if _, err := refused.Do(context.Background(), freeAddress(t), NewRequest(MethodPost, "/scores", nil)); !errors.Is(err, ErrConnectionRefused) {
+		t.Errorf("Expected a refused connection, got %v", err)
+	}
+}
+
+func TestClientContextCancel(t *testing.T) {
+	address := rawServer(t, func(conn net.Conn) { time.Sleep(2 * time.Second) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := (&Client{}).Fetch(ctx, address, "/")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Cancel did not interrupt the read")
+	}
+}
+
+func TestClientRedirects(t *testing.T) {
+	other := NewServeMux()
+	other.HandleFunc("/moved.jtl", func(w ResponseWriter, r *Request) { w.SendGood("arrived "+r.Method, "jtl") })
+	otherAddress := startServer(t, &Server{Handler: other})
+
+	mux := NewServeMux()
+	mux.HandleFunc("/old.jtl", func(w ResponseWriter, r *Request) { Redirect(w, "new/page.jtl", 301) })
+	mux.HandleFunc("/new/page.jtl", func(w ResponseWriter, r *Request) {
+		Redirect(w, "jtltp://"+otherAddress+"/moved.jtl", 302)
+	})
+	mux.HandleFunc("/form", func(w ResponseWriter, r *Request) { Redirect(w, "/echo", 307) })
+	mux.HandleFunc("/echo", func(w ResponseWriter, r *Request) { w.SendGood(r.Method+" "+string(r.Body), "txt") })
+	mux.HandleFunc("/ping", func(w ResponseWriter, r *Request) { Redirect(w, "/pong", 302) })
+	mux.HandleFunc("/pong", func(w ResponseWriter, r *Request) { Redirect(w, "/ping", 302) })
+	mux.HandleFunc("/hop/", func(w ResponseWriter, r *Request) { Redirect(w, r.Path+"x", 302) })
+	address := startServer(t, &Server{Handler: mux})
+
+	client := &Client{MaxRedirects: 5}
+	resp, err := client.Get(context.Background(), "jtltp://"+address+"/old.jtl")
+	if err != nil {
+		t.Fatalf("Following redirects failed: %v", err)
+	}
+	if string(resp.Body) != "arrived GET" {
+		t.Errorf("Unexpected body: %q", resp.Body)
+	}
+	if resp.URL != "jtltp://"+otherAddress+"/moved.jtl" {
+		t.Errorf("Expected the final URL, got %s", resp.URL)
+	}
+
+	// 307 repeats the request with its method and body
+	resp, err = client.DoURL(context.Background(), "jtltp://"+address+"/form", NewRequest(MethodPost, "", []byte("a=1")))
+	if err != nil || string(resp.Body) != "POST a=1" {
+		t.Errorf("307 did not keep the request: %v, %v", resp, err)
+	}
+
+	if _, err := client.Get(context.Background(), "jtltp://"+address+"/ping"); !errors.Is(err, ErrRedirectLoop) {
+		t.Errorf("Expected ErrRedirectLoop, got %v", err)
+	}
+	if _, err := client.Get(context.Background(), "jtltp://"+address+"/hop/"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Expected ErrTooManyRedirects, got %v", err)
+	}
+
+	// with redirects turned off the 301 itself comes back
+	resp, _ = (&Client{MaxRedirects: -1}).Get(context.Background(), "jtltp://"+address+"/old.jtl")
+	if resp == nil || resp.Status != 301 || resp.Header.Get("location") != "new/page.jtl" {
+		t.Errorf("Expected the raw redirect, got %v", resp)
+	}
+}
+
+func TestClientDump(t *testing.T) {
+	address := startServer(t, &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.SendGood("line one\nline two", "txt")
+	})})
+
+	var dump bytes.Buffer
+	client := &Client{Dump: &dump, DisableCompression: true}
+	req := NewRequest(MethodPost, "/echo", []byte("sent"))
+	if _, err := client.Do(context.Background(), address, req); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+
+	want := "> JTLTP-VERSION=[2] JTLTP-POST=[/echo] te=[chunked] host=[127.0.0.1] JTLTP-LENGTH=[4]\n" +
+		"> sent\n" +
+		"< JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[txt] JTLTP-LENGTH=[17]\n" +
+		"< line one\n" +
+		"< line two"
+	if dump.String() != want {
+		t.Errorf("Unexpected dump:\n%s\nwant:\n%s", dump.String(), want)
+	}
+}
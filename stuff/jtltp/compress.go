@@ -0,0 +1,206 @@
+package jtltp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Content encodings understood by Compress and by the Client.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate" // zlib format, like HTTP's deflate
+)
+
+// acceptEncoding is what clients send unless DisableCompression is set
+const acceptEncoding = EncodingGzip + ", " + EncodingDeflate
+
+// compressMinSize is the smallest body Compress bothers with
+const compressMinSize = 1024
+
+// Compress wraps h so bodies are gzipped or deflated for clients that list
+// the encoding in their accept-encoding header. The response then carries
+// content-encoding. Small bodies and bodies that don't shrink are sent as
+// they are, and so are subscriptions, whose messages must each be readable.
+func Compress(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		encoding := chooseEncoding(r.Header.Get("accept-encoding"))
+		if encoding == "" || r.Method == MethodSubscribe {
+			h.ServeJTLTP(w, r)
+			return
+		}
+		h.ServeJTLTP(&compressWriter{ResponseWriter: w, encoding: encoding, chunked: r.Header.Get("te") == "chunked"}, r)
+	})
+}
+
+// chooseEncoding picks gzip over deflate from an accept-encoding list like
+// "deflate, gzip;q=0.5". Encodings with q=0 are refused.
+func chooseEncoding(accept string) string {
+	accepted := map[string]bool{}
+	for _, item := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		if q := strings.TrimSpace(params); q == "q=0" || q == "q=0.0" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate} {
+		if accepted[encoding] || accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+type compressWriter struct {
+	ResponseWriter
+	encoding string
+	chunked  bool
+}
+
+// Stream compresses the body as it's written, each Write flushed out as one
+// chunk. Clients that can't read chunks get the body compressed in one piece.
+func (w *compressWriter) Stream(status int, doctype string) io.WriteCloser {
+	if !w.chunked {
+		return &bufferedStream{w: w, status: status, doctype: doctype}
+	}
+	if w.Header().Get("content-encoding") != "" {
+		// already encoded by the handler
+		return Stream(w.ResponseWriter, status, doctype)
+	}
+	w.Header().Set("content-encoding", w.encoding)
+	s := &compressStream{stream: Stream(w.ResponseWriter, status, doctype)}
+	s.zw, _ = newCompressor(w.encoding, &s.buf)
+	return s
+}
+
+// compressStream compresses into buf and hands what each Write produced to
+// stream as one piece
+type compressStream struct {
+	stream io.WriteCloser
+	zw     compressor
+	buf    bytes.Buffer
+}
+
+func (s *compressStream) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := s.zw.Write(p); err != nil {
+		return 0, err
+	}
+	if err := s.zw.Flush(); err != nil {
+		return 0, err
+	}
+	if err := s.send(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *compressStream) Close() error {
+	if err := s.zw.Close(); err != nil {
+		return err
+	}
+	if err := s.send(); err != nil {
+		return err
+	}
+	return s.stream.Close()
+}
+
+func (s *compressStream) send() error {
+	defer s.buf.Reset()
+	_, err := s.stream.Write(s.buf.Bytes())
+	return err
+}
+
+func (w *compressWriter) SendGood(message string, doctype string) {
+	w.Send(200, doctype, []byte(message))
+}
+
+func (w *compressWriter) SendBad(message string, doctype string) {
+	w.Send(400, doctype, []byte(message))
+}
+
+func (w *compressWriter) Send404() {
+	w.Send(404, "jtl", []byte("Not Found"))
+}
+
+func (w *compressWriter) Send(status int, doctype string, body []byte) {
+	if len(body) >= compressMinSize && w.Header().Get("content-encoding") == "" {
+		if compressed, err := compress(w.encoding, body); err == nil && len(compressed) < len(body) {
+			w.Header().Set("content-encoding", w.encoding)
+			body = compressed
+		}
+	}
+	w.ResponseWriter.Send(status, doctype, body)
+}
+
+// compressor is a gzip or zlib writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newCompressor(encoding string, w io.Writer) (compressor, error) {
+	switch encoding {
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case EncodingDeflate:
+		return zlib.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("jtltp: unknown encoding %q", encoding)
+}
+
+func compress(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := newCompressor(encoding, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress undoes the content-encoding of resp in place. Bodies that
+// inflate past MaxBodySize are refused.
+func decompress(resp *Response) error {
+	encoding := strings.ToLower(resp.Header.Get("content-encoding"))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	var zr io.ReadCloser
+	var err error
+	switch encoding {
+	case EncodingGzip:
+		zr, err = gzip.NewReader(bytes.NewReader(resp.Body))
+	case EncodingDeflate:
+		zr, err = zlib.NewReader(bytes.NewReader(resp.Body))
+	default:
+		return fmt.Errorf("%w: unknown content-encoding %q", ErrMalformedResponse, encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+	defer zr.Close()
+
+	body, err := io.ReadAll(io.LimitReader(zr, MaxBodySize+1))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+	if len(body) > MaxBodySize {
+		return fmt.Errorf("%w: body inflates past %d bytes", ErrMalformedResponse, MaxBodySize)
+	}
+	resp.Body = body
+	resp.Header.Del("content-encoding")
+	return nil
+}
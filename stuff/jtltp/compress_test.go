@@ -0,0 +1,141 @@
+package jtltp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChooseEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip, deflate", EncodingGzip},
+		{"deflate", EncodingDeflate},
+		{"Deflate, GZIP", EncodingGzip},
+		{"gzip;q=0, deflate", EncodingDeflate},
+		{"br", ""},
+		{"*", EncodingGzip},
+	}
+	for _, tt := range tests {
+		if got := chooseEncoding(tt.accept); got != tt.want {
+			t.Errorf("chooseEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	page := strings.Repeat(">id=\"row\">p>the same markup again and again;\n", 200)
+	mux := NewServeMux()
+	mux.HandleFunc("/big.jtl", func(w ResponseWriter, r *Request) { w.SendGood(page, "jtl") })
+	mux.HandleFunc("/small.jtl", func(w ResponseWriter, r *Request) { w.SendGood("tiny", "jtl") })
+	address := startServer(t, &Server{Handler: Compress(mux)})
+
+	// decompressed transparently
+	resp, err := (&Client{}).Fetch(context.Background(), address, "/big.jtl")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if string(resp.Body) != page || resp.Header.Get("content-encoding") != "" {
+		t.Errorf("Body was not decompressed: %d bytes, content-encoding %q", len(resp.Body), resp.Header.Get("content-encoding"))
+	}
+	if result, err := JtltpFetch(address, "/big.jtl"); err != nil || result["JTLTP"] != page {
+		t.Errorf("JtltpFetch did not decompress: %v", err)
+	}
+
+	// with its own accept-encoding the caller gets the raw body
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate} {
+		req := NewRequest(MethodGet, "/big.jtl", nil)
+		req.Header.Set("accept-encoding", encoding)
+		resp, err := (&Client{}).Do(context.Background(), address, req)
+		if err != nil {
+			t.Fatalf("%s: fetch failed: %v", encoding, err)
+		}
+		if resp.Header.Get("content-encoding") != encoding || len(resp.Body) >= len(page) {
+			t.Errorf("%s: expected a compressed body, got %d bytes with %q", encoding, len(resp.Body), resp.Header.Get("content-encoding"))
+		}
+		if err := decompress(resp); err != nil || string(resp.Body) != page {
+			t.Errorf("%s: body did not round trip: %v", encoding, err)
+		}
+	}
+
+	resp, err = (&Client{DisableCompression: true}).Fetch(context.Background(), address, "/big.jtl")
+	if err != nil || resp.Header.Get("content-encoding") != "" || string(resp.Body) != page {
+		t.Errorf("DisableCompression still got a compressed body: %v", err)
+	}
+
+	req := NewRequest(MethodGet, "/small.jtl", nil)
+	req.Header.Set("accept-encoding", "gzip")
+	resp, err = (&Client{}).Do(context.Background(), address, req)
+	if err != nil || resp.Header.Get("content-encoding") != "" || string(resp.Body) != "tiny" {
+		t.Errorf("Small bodies should be sent as they are: %v, %v", resp, err)
+	}
+}
+
+func TestCompressionStream(t *testing.T) {
+	root := t.TempDir()
+	page := bytes.Repeat([]byte(">id=\"row\">p>streamed;\n"), 10000)
+	os.WriteFile(filepath.Join(root, "big.jtl"), page, 0o644)
+	address := startServer(t, &Server{Handler: Compress(FileServer(root))})
+
+	// big files are streamed, compressed all the same
+	var wire bytes.Buffer
+	resp, err := (&Client{Dump: &wire}).Fetch(context.Background(), address, "/big.jtl")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if !bytes.Equal(resp.Body, page) {
+		t.Errorf("Body differs: got %d bytes, want %d", len(resp.Body), len(page))
+	}
+	if !strings.Contains(wire.String(), "content-encoding=[gzip]") || strings.Contains(wire.String(), "streamed;") || wire.Len() >= len(page)/10 {
+		t.Errorf("Expected the stream to be compressed on the wire, got %d bytes:\n%.500s", wire.Len(), wire.String())
+	}
+
+	// GetStream gets it in one piece once it's decompressed
+	pieces := 0
+	resp, err = (&Client{}).GetStream(context.Background(), "jtltp://"+address+"/big.jtl", func(resp *Response, chunk []byte) {
+		pieces++
+		if !bytes.Equal(chunk, page) {
+			t.Errorf("Piece differs: got %d bytes, want %d", len(chunk), len(page))
+		}
+	})
+	if err != nil || pieces != 1 {
+		t.Errorf("Expected one piece, got %d: %v", pieces, err)
+	}
+
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate} {
+		req := NewRequest(MethodGet, "/big.jtl", nil)
+		req.Header.Set("accept-encoding", encoding)
+		resp, err := (&Client{}).Do(context.Background(), address, req)
+		if err != nil {
+			t.Fatalf("%s: fetch failed: %v", encoding, err)
+		}
+		if resp.Header.Get("content-encoding") != encoding {
+			t.Errorf("%s: got content-encoding %q", encoding, resp.Header.Get("content-encoding"))
+		}
+		if err := decompress(resp); err != nil || !bytes.Equal(resp.Body, page) {
+			t.Errorf("%s: body did not round trip: %v", encoding, err)
+		}
+	}
+}
+
+func TestCompressionCorrupt(t *testing.T) {
+	address := rawServer(t, func(conn net.Conn) {
+		readFrame(bufio.NewReader(conn))
+		writeFrame(conn, &frame{
+			fields: []field{{fieldStatus, "200"}, {fieldType, "jtl"}, {"content-encoding", "gzip"}},
+			body:   []byte("definitely not gzip"),
+		})
+	})
+	if _, err := (&Client{}).Fetch(context.Background(), address, "/"); !errors.Is(err, ErrMalformedResponse) {
+		t.Errorf("Expected ErrMalformedResponse, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package jtltp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer answers every request with its verb, path, x header and body,
+// so a test can see exactly what the server understood
+func echoServer(t testing.TB, readTimeout time.Duration) string {
+	t.Helper()
+	return startServer(t, &Server{ReadTimeout: readTimeout, Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.SendGood(r.Method+" "+r.Path+" "+r.Header.Get("x")+"\n"+string(r.Body), "txt")
+	})})
+}
+
+// exchange writes the pieces of raw to a new connection, pausing between
+// them, and returns the status and body of the answer. Status 0 means the
+// server hung up without answering.
+func exchange(t *testing.T, address string, pause time.Duration, pieces ...[]byte) (int, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	go func() {
+		for i, piece := range pieces {
+			if i > 0 {
+				time.Sleep(pause)
+			}
+			if _, err := conn.Write(piece); err != nil {
+				return
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	framed, err := isFramed(reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) || isReset(err) {
+			return 0, ""
+		}
+		t.Fatalf("Reading the answer failed: %v", err)
+	}
+	var message *frame
+	if framed {
+		message, err = readFrame(reader)
+	} else {
+		message, err = readLegacyResponse(reader)
+	}
+	if err != nil {
+		t.Fatalf("Malformed answer: %v", err)
+	}
+	resp, err := responseFromFrame(message)
+	if err != nil {
+		t.Fatalf("Malformed answer: %v", err)
+	}
+	return resp.Status, string(resp.Body)
+}
+
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+// request builds a version 2 request by hand, fields are written as given
+func request(fields string, body string) []byte {
+	return []byte(fmt.Sprintf("JTLTP-VERSION=[2] %s JTLTP-LENGTH=[%d]\n%s", fields, len(body), body))
+}
+
+func TestConformanceRequests(t *testing.T) {
+	address := echoServer(t, time.Second)
+	tests := []struct {
+		name   string
+		raw    []byte
+		status int // 0 for a hang up without an answer
+		body   string
+	}{
+		{"get", request("JTLTP-GET=[/index.jtl]", ""), 200, "GET /index.jtl \n"},
+		{"post", request("JTLTP-POST=[/scores]", "james=42"), 200, "POST /scores \njames=42"},
+		{"put", request("JTLTP-PUT=[/scores]", "x"), 200, "PUT /scores \nx"},
+		{"body with brackets", request("JTLTP-POST=[/p]", "a]b] JTLTP-LENGTH=[0]\n]"), 200, "POST /p \na]b] JTLTP-LENGTH=[0]\n]"},
+		{"escaped values", request(`JTLTP-GET=[/a\]b] x=[one\\two\nthree\]]`, ""), 200, "GET /a]b one\\two\nthree]\n"},
+		{"header case", request("JTLTP-GET=[/] X=[upper]", ""), 200, "GET / upper\n"},
+		{"empty body length", []byte("JTLTP-VERSION=[2] JTLTP-GET=[/] JTLTP-LENGTH=[0]\n"), 200, "GET / \n"},
+		{"legacy", []byte("JTLTP-GET=[/old.jtl]"), 200, "GET /old.jtl \n"},
+
+		{"unknown verb", request("JTLTP-DELETE=[/]", ""), 400, "Bad Request"},
+		{"no verb", request("x=[y]", ""), 400, "Bad Request"},
+		{"two verbs", request("JTLTP-GET=[/a] JTLTP-POST=[/b]", ""), 400, "Bad Request"},
+		{"empty path", request("JTLTP-GET=[]", ""), 400, "Bad Request"},
+		{"get with body", request("JTLTP-GET=[/]", "body"), 200, "GET / \nbody"},
+		{"missing length", []byte("JTLTP-VERSION=[2] JTLTP-GET=[/]\n"), 400, "Bad Request"},
+		{"negative length", []byte("JTLTP-VERSION=[2] JTLTP-GET=[/] JTLTP-LENGTH=[-1]\n"), 400, "Bad Request"},
+		{"length not a number", []byte("JTLTP-VERSION=[2] JTLTP-GET=[/] JTLTP-LENGTH=[ten]\n"), 400, "Bad Request"},
+		{"oversized body", []byte("JTLTP-VERSION=[2] JTLTP-POST=[/] JTLTP-LENGTH=[" + strconv.Itoa(MaxBodySize+1) + "]\n"), 400, "Bad Request"},
+		{"bad escape", request(`JTLTP-GET=[/\x]`, ""), 400, "Bad Request"},
+		{"unterminated value", []byte("JTLTP-VERSION=[2] JTLTP-GET=[/\n"), 400, "Bad Request"},
+		{"bad field name", request("JTLTP-GET=[/] x y=[z]", ""), 400, "Bad Request"},
+		{"no space between fields", []byte("JTLTP-VERSION=[2]JTLTP-GET=[/] JTLTP-LENGTH=[0]\n"), 400, "Bad Request"},
+		{"header too large", []byte("JTLTP-VERSION=[2] x=[" + strings.Repeat("a", maxHeaderSize) + "]\n"), 400, "Bad Request"},
+		{"unsupported version", []byte("JTLTP-VERSION=[3] JTLTP-GET=[/] JTLTP-LENGTH=[0]\n"), 505, "Version Not Supported"},
+		{"short body", []byte("JTLTP-VERSION=[2] JTLTP-POST=[/] JTLTP-LENGTH=[10]\nshort"), 0, ""},
+		{"legacy garbage", []byte("hello]"), 400, "Bad Request"},
+		{"legacy too large", []byte("JTLTP-GET=[" + strings.Repeat("a", maxHeaderSize+1)), 400, "Bad Request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := tt.raw
+			if tt.name == "short body" {
+				// hang up halfway through the body
+				conn, err := net.Dial("tcp", address)
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.Write(raw)
+				conn.(*net.TCPConn).CloseWrite()
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				reply, _ := io.ReadAll(conn)
+				conn.Close()
+				if len(reply) != 0 {
+					t.Errorf("Expected no answer, got %q", reply)
+				}
+				return
+			}
+			status, body := exchange(t, address, 0, raw)
+			if status != tt.status || body != tt.body {
+				t.Errorf("Got %d %q, want %d %q", status, body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestConformancePartialReads(t *testing.T) {
+	address := echoServer(t, time.Second)
+	raw := request("JTLTP-POST=[/scores] x=[a\\]b]", "james=42]")
+	want := "POST /scores a]b\njames=42]"
+
+	// split everywhere: inside names, values, escapes, the newline and the body
+	for i := 1; i < len(raw); i++ {
+		status, body := exchange(t, address, time.Millisecond, raw[:i], raw[i:])
+		if status != 200 || body != want {
+			t.Fatalf("Split at %d (%q): got %d %q", i, raw[:i], status, body)
+		}
+	}
+
+	pieces := make([][]byte, len(raw))
+	for i := range raw {
+		pieces[i] = raw[i : i+1]
+	}
+	if status, body := exchange(t, address, time.Millisecond, pieces...); status != 200 || body != want {
+		t.Errorf("Byte at a time: got %d %q", status, body)
+	}
+}
+
+func TestConformanceSlowClients(t *testing.T) {
+	address := echoServer(t, 300*time.Millisecond)
+	raw := request("JTLTP-GET=[/slow.jtl]", "")
+
+	// slow but within the read timeout
+	if status, _ := exchange(t, address, 50*time.Millisecond, raw[:10], raw[10:20], raw[20:]); status != 200 {
+		t.Errorf("Slow client: got %d, want 200", status)
+	}
+
+	// stalls past the read timeout, halfway through the header or the body
+	stalled := [][][]byte{
+		{raw[:10]},
+		{request("JTLTP-POST=[/]", "0123456789")[:45]},
+		{},
+	}
+	for _, pieces := range stalled {
+		start := time.Now()
+		if status, _ := exchange(t, address, 0, pieces...); status != 0 {
+			t.Errorf("Stalled client %q: got %d, want a hang up", pieces, status)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Stalled client %q held the connection for %v", pieces, elapsed)
+		}
+	}
+}
+
+func TestConformanceResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		body   string
+		err    error
+	}{
+		{"body with brackets", "JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP-LENGTH=[5]\n]a]b]", 200, "]a]b]", nil},
+		{"escaped header", "JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] x=[\\]] JTLTP-LENGTH=[0]\n", 200, "", nil},
+		{"legacy", "JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP=MSG=[old ] page]", 200, "old ] page", nil},
+		{"missing status", "JTLTP-VERSION=[2] JTLTP-TYPE=[jtl] JTLTP-LENGTH=[0]\n", 0, "", ErrMalformedResponse},
+		{"bad length", "JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-LENGTH=[x]\n", 0, "", ErrMalformedResponse},
+		{"oversized body", "JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-LENGTH=[" + strconv.Itoa(MaxBodySize+1) + "]\n", 0, "", ErrMalformedResponse},
+		{"truncated chunk", "JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] transfer-encoding=[chunked] JTLTP-LENGTH=[0]\nJTLTP-VERSION=[2] JTLTP-LENGTH=[5]\nab", 0, "", ErrMalformedResponse},
+		{"unknown encoding", "JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] content-encoding=[br] JTLTP-LENGTH=[0]\n", 0, "", ErrMalformedResponse},
+	}
+	client := &Client{ReadTimeout: time.Second}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := rawServer(t, func(conn net.Conn) {
+				bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte(tt.raw))
+			})
+			resp, err := client.Fetch(context.Background(), address, "/")
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil || resp.Status != tt.status || !bytes.Equal(resp.Body, []byte(tt.body)) {
+				t.Errorf("Got %v, %v, want %d %q", resp, err, tt.status, tt.body)
+			}
+		})
+	}
+}
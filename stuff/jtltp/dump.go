@@ -0,0 +1,74 @@
+package jtltp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+)
+
+// dumpConn copies the traffic of a connection to Client.Dump, each line
+// marked > for sent or < for received
+type dumpConn struct {
+	net.Conn
+	dump *dumper
+}
+
+type dumper struct {
+	mu   sync.Mutex
+	w    io.Writer
+	last byte // direction of the line in progress, 0 at the start of a line
+}
+
+func (c *dumpConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.dump.write('>', p[:n])
+	return n, err
+}
+
+func (c *dumpConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.dump.write('<', p[:n])
+	return n, err
+}
+
+func (d *dumper) write(direction byte, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b bytes.Buffer
+	if d.last != 0 && d.last != direction {
+		// the other side cut in before the line was done
+		b.WriteByte('\n')
+		d.last = 0
+	}
+	for len(p) > 0 {
+		if d.last == 0 {
+			b.WriteByte(direction)
+			b.WriteByte(' ')
+			d.last = direction
+		}
+		line, rest, found := bytes.Cut(p, []byte("\n"))
+		b.Write(line)
+		if found {
+			b.WriteByte('\n')
+			d.last = 0
+		}
+		p = rest
+	}
+	d.w.Write(b.Bytes())
+}
+
+// getDumper returns the dumper shared by the client's connections, so their
+// lines don't get mixed up
+func (c *Client) getDumper() *dumper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dumper == nil || c.dumper.w != c.Dump {
+		c.dumper = &dumper{w: c.Dump}
+	}
+	return c.dumper
+}
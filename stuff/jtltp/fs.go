@@ -0,0 +1,152 @@
+package jtltp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// IndexFile is served when a request names a directory.
+const IndexFile = "index.jtl"
+
+// Files from fileStreamSize on are streamed in pieces of fileChunkSize.
+const (
+	fileStreamSize = 64 << 10
+	fileChunkSize  = 16 << 10
+)
+
+// TimeFormat is the format of times in headers like last-modified, always
+// in UTC.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var extensionTypes = map[string]string{
+	".jtl":  "jtl",
+	".lua":  "lua",
+	".png":  "png",
+	".txt":  "txt",
+	".jpg":  "jpg",
+	".jpeg": "jpg",
+	".json": "json",
+}
+
+// TypeByExtension returns the JTLTP-TYPE for a file name, "bin" if the
+// extension is unknown.
+func TypeByExtension(name string) string {
+	if doctype, ok := extensionTypes[strings.ToLower(path.Ext(name))]; ok {
+		return doctype
+	}
+	return "bin"
+}
+
+type fileHandler struct {
+	root string
+}
+
+// FileServer returns a handler serving the directory tree at root. Requests
+// can't reach outside of root, neither with .. nor through symlinks.
+func FileServer(root string) Handler {
+	return &fileHandler{root: root}
+}
+
+func (h *fileHandler) ServeJTLTP(w ResponseWriter, r *Request) {
+	// a query is for whoever makes the page, not part of the file name
+	urlPath, _, _ := strings.Cut(r.Path, "?")
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == ".." {
+			w.SendBad("Bad Request", "txt")
+			return
+		}
+	}
+
+	name := strings.TrimPrefix(cleanPath(urlPath), "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += IndexFile
+	}
+
+	file, err := os.OpenInRoot(h.root, name)
+	if err != nil {
+		sendOpenError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		w.Send(500, "txt", []byte("Internal Server Error"))
+		return
+	}
+	if info.IsDir() {
+		name = path.Join(name, IndexFile)
+		file, err = os.OpenInRoot(h.root, name)
+		if err != nil {
+			sendOpenError(w, err)
+			return
+		}
+		defer file.Close()
+		if info, err = file.Stat(); err != nil {
+			w.Send(500, "txt", []byte("Internal Server Error"))
+			return
+		}
+	}
+
+	// clients with a cached copy only need the body if the file changed
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	modified := info.ModTime().UTC().Truncate(time.Second)
+	w.Header().Set("etag", etag)
+	w.Header().Set("last-modified", modified.Format(TimeFormat))
+	if notModified(r, etag, modified) {
+		w.Send(304, TypeByExtension(name), nil)
+		return
+	}
+
+	// big files go out in chunks, so pages can be shown while they load
+	if info.Size() > MaxBodySize {
+		w.Send(500, "txt", []byte("File Too Large"))
+		return
+	}
+	if info.Size() >= fileStreamSize {
+		stream := Stream(w, 200, TypeByExtension(name))
+		buf := make([]byte, fileChunkSize)
+		// hiding the file's WriteTo makes every read one chunk
+		io.CopyBuffer(stream, struct{ io.Reader }{file}, buf)
+		stream.Close()
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(file, MaxBodySize+1))
+	if err != nil {
+		w.Send(500, "txt", []byte("Internal Server Error"))
+		return
+	}
+	if len(body) > MaxBodySize {
+		w.Send(500, "txt", []byte("File Too Large"))
+		return
+	}
+	w.Send(200, TypeByExtension(name), body)
+}
+
+// notModified reports whether the client's cached copy, named by the
+// if-none-match and if-modified-since headers, is still current
+func notModified(r *Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("if-none-match"); match != "" {
+		return match == etag || match == "*"
+	}
+	since, err := time.Parse(TimeFormat, r.Header.Get("if-modified-since"))
+	return err == nil && !modified.After(since)
+}
+
+// sendOpenError maps a failed open to a status. Besides permission errors,
+// os.Root refuses paths that leave the root through a symlink; both are
+// answered with 403.
+func sendOpenError(w ResponseWriter, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		w.Send404()
+		return
+	}
+	w.Send(403, "txt", []byte("Forbidden"))
+}
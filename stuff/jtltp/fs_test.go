@@ -0,0 +1,67 @@
+package jtltp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileServer(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "site")
+	files := map[string]string{
+		"index.jtl":        ">>>DOCTYPE=JTL",
+		"scripts/main.lua": "print(1)",
+		"img/logo.png":     "\x89PNG\x00\x01",
+		"docs/index.jtl":   "docs index",
+		"docs/readme.txt":  "read me",
+		"../secret.txt":    "outside",
+		"data/unknown.xyz": "???",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	address := startServer(t, &Server{Handler: FileServer(root)})
+
+	tests := []struct {
+		path    string
+		status  string
+		doctype string
+		body    string
+	}{
+		{"/", "200", "jtl", ">>>DOCTYPE=JTL"},
+		{"/index.jtl", "200", "jtl", ">>>DOCTYPE=JTL"},
+		{"/index.jtl?x=1", "200", "jtl", ">>>DOCTYPE=JTL"},
+		{"/?x=1", "200", "jtl", ">>>DOCTYPE=JTL"},
+		{"/docs/readme.txt?a=b&c", "200", "txt", "read me"},
+		{"/../secret.txt?x=1", "400", "txt", "Bad Request"},
+		{"/scripts/main.lua", "200", "lua", "print(1)"},
+		{"/img/logo.png", "200", "png", "\x89PNG\x00\x01"},
+		{"/docs", "200", "jtl", "docs index"},
+		{"/docs/readme.txt", "200", "txt", "read me"},
+		{"/data/unknown.xyz", "200", "bin", "???"},
+		{"/missing.jtl", "404", "jtl", "Not Found"},
+		{"/../secret.txt", "400", "txt", "Bad Request"},
+		{"/docs/../../secret.txt", "400", "txt", "Bad Request"},
+		{"/link.txt", "403", "txt", "Forbidden"},
+	}
+	for _, tt := range tests {
+		result, err := JtltpFetch(address, tt.path)
+		if err != nil {
+			t.Errorf("%s: fetch failed: %v", tt.path, err)
+			continue
+		}
+		if result["JTLTP-STATUS"] != tt.status || result["JTLTP-TYPE"] != tt.doctype || result["JTLTP"] != tt.body {
+			t.Errorf("%s: got %s %s %q, want %s %s %q", tt.path,
+				result["JTLTP-STATUS"], result["JTLTP-TYPE"], result["JTLTP"], tt.status, tt.doctype, tt.body)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package jtltp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Run one with go test -fuzz=FuzzReadFrame ./stuff/jtltp, the seeds below
+// also run as part of the normal tests.
+
+var fuzzSeeds = []string{
+	"JTLTP-VERSION=[2] JTLTP-GET=[/index.jtl] JTLTP-LENGTH=[0]\n",
+	"JTLTP-VERSION=[2] JTLTP-POST=[/scores] x=[a\\]b\\\\c\\n] JTLTP-LENGTH=[9]\njames=42]",
+	"JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] etag=[\"1-2\"] JTLTP-LENGTH=[5]\nhello",
+	"JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] transfer-encoding=[chunked] JTLTP-LENGTH=[0]\nJTLTP-VERSION=[2] JTLTP-LENGTH=[0]\n",
+	"JTLTP-VERSION=[2] JTLTP-GET=[/] JTLTP-GET=[/] JTLTP-LENGTH=[0]\n",
+	"JTLTP-VERSION=[2] JTLTP-LENGTH=[99999999999]\n",
+	"JTLTP-VERSION=[2] JTLTP-GET=[/\\",
+	"JTLTP-VERSION=[3] JTLTP-GET=[/] JTLTP-LENGTH=[0]\r\n",
+	"JTLTP-GET=[/old.jtl]",
+	"JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP=MSG=[old ] page]",
+	"",
+}
+
+// withoutFraming leaves out the fields writeFrame adds by itself
+func withoutFraming(fields []field) []field {
+	var kept []field
+	for _, fl := range fields {
+		if fl.name != fieldVersion && fl.name != fieldLength {
+			kept = append(kept, fl)
+		}
+	}
+	return kept
+}
+
+func FuzzReadFrame(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message, err := readFrame(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+
+		// whatever was read must survive being written again
+		var buf bytes.Buffer
+		if err := writeFrame(&buf, message); err != nil {
+			t.Fatalf("writeFrame of a parsed frame failed: %v", err)
+		}
+		again, err := readFrame(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("Rereading %q failed: %v", buf.String(), err)
+		}
+		if !reflect.DeepEqual(withoutFraming(message.fields), withoutFraming(again.fields)) || !bytes.Equal(message.body, again.body) {
+			t.Errorf("Round trip changed the frame: %v became %v", message, again)
+		}
+	})
+}
+
+func FuzzRequest(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bufio.NewReader(bytes.NewReader(data))
+		framed, err := isFramed(reader)
+		if err != nil {
+			return
+		}
+		var message *frame
+		if framed {
+			message, err = readFrame(reader)
+		} else {
+			message, err = readLegacyRequest(reader)
+		}
+		if err != nil {
+			return
+		}
+		req, err := requestFromFrame(message)
+		if err != nil {
+			return
+		}
+
+		out, err := req.toFrame()
+		if err != nil {
+			// GET with a body is read but never written
+			return
+		}
+		var buf bytes.Buffer
+		if err := writeFrame(&buf, out); err != nil {
+			t.Fatalf("writeFrame failed: %v", err)
+		}
+		again, err := readFrame(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("Rereading failed: %v", err)
+		}
+		reqAgain, err := requestFromFrame(again)
+		if err != nil {
+			t.Fatalf("Rereading %q failed: %v", buf.String(), err)
+		}
+		if reqAgain.Method != req.Method || reqAgain.Path != req.Path || !bytes.Equal(reqAgain.Body, req.Body) || !reflect.DeepEqual(reqAgain.Header, req.Header) {
+			t.Errorf("Round trip changed the request: %+v became %+v", req, reqAgain)
+		}
+	})
+}
+
+func FuzzResponse(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bufio.NewReader(bytes.NewReader(data))
+		framed, err := isFramed(reader)
+		if err != nil {
+			return
+		}
+		var message *frame
+		if framed {
+			message, err = readFrame(reader)
+		} else {
+			message, err = readLegacyResponse(reader)
+		}
+		if err != nil {
+			return
+		}
+		resp, err := responseFromFrame(message)
+		if err != nil {
+			return
+		}
+		if resp.Header.Get("transfer-encoding") == "chunked" {
+			// readChunks only needs the connection for its deadlines
+			conn, other := net.Pipe()
+			defer conn.Close()
+			defer other.Close()
+			readChunks(t.Context(), conn, reader, time.Second, resp, nil, false)
+		}
+		decompress(resp)
+	})
+}
+
+// FuzzServer sends anything to a real server, which must answer with a well
+// formed response or hang up, and never keep the connection waiting.
+func FuzzServer(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	address := startServer(f, &Server{
+		ReadTimeout: 500 * time.Millisecond,
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.SendGood(r.Method+" "+r.Path+"\n"+string(r.Body), "txt")
+		}),
+	})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write(data)
+		conn.(*net.TCPConn).CloseWrite()
+
+		reply, err := io.ReadAll(conn)
+		if err != nil && !isReset(err) {
+			t.Fatalf("Reading the answer to %q failed: %v", data, err)
+		}
+		if len(reply) == 0 {
+			return
+		}
+
+		reader := bufio.NewReader(bytes.NewReader(reply))
+		framed, err := isFramed(reader)
+		if err != nil {
+			t.Fatalf("Bad answer %q: %v", reply, err)
+		}
+		if framed {
+			_, err = readFrame(reader)
+		} else {
+			_, err = readLegacyResponse(reader)
+		}
+		if err != nil {
+			t.Fatalf("Bad answer %q to %q: %v", reply, data, err)
+		}
+	})
+}
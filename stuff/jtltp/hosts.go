@@ -0,0 +1,91 @@
+package jtltp
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// HostMux routes requests by the host header clients send with the name they
+// connected to, so one server can serve several sites. A pattern is either a
+// host name like "blog.example.com", or "*.example.com" for every name under
+// example.com. "*" catches the rest, including old clients that send no host.
+// Exact names win over wildcards, longer wildcards over shorter ones.
+type HostMux struct {
+	mu    sync.RWMutex
+	hosts map[string]Handler
+}
+
+func NewHostMux() *HostMux {
+	return &HostMux{hosts: make(map[string]Handler)}
+}
+
+// Handle registers handler for host. It panics if host is empty or already
+// registered.
+func (mux *HostMux) Handle(host string, handler Handler) {
+	if host == "" {
+		panic("jtltp: empty host")
+	}
+	if handler == nil {
+		panic("jtltp: nil handler")
+	}
+	host = cleanHost(host)
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if mux.hosts == nil {
+		mux.hosts = make(map[string]Handler)
+	}
+	if _, exists := mux.hosts[host]; exists {
+		panic("jtltp: multiple registrations for host " + host)
+	}
+	mux.hosts[host] = handler
+}
+
+func (mux *HostMux) HandleFunc(host string, handler func(ResponseWriter, *Request)) {
+	mux.Handle(host, HandlerFunc(handler))
+}
+
+// Handler returns the handler for r and the host pattern it was registered
+// with. If nothing matches it returns NotFoundHandler and an empty pattern.
+func (mux *HostMux) Handler(r *Request) (Handler, string) {
+	host := cleanHost(r.Header.Get("host"))
+
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	if host != "" {
+		if handler, ok := mux.hosts[host]; ok {
+			return handler, host
+		}
+		// blog.example.com tries *.example.com, then *.com
+		for rest := host; ; {
+			_, parent, found := strings.Cut(rest, ".")
+			if !found {
+				break
+			}
+			if handler, ok := mux.hosts["*."+parent]; ok {
+				return handler, "*." + parent
+			}
+			rest = parent
+		}
+	}
+	if handler, ok := mux.hosts["*"]; ok {
+		return handler, "*"
+	}
+	return NotFoundHandler(), ""
+}
+
+func (mux *HostMux) ServeJTLTP(w ResponseWriter, r *Request) {
+	handler, _ := mux.Handler(r)
+	handler.ServeJTLTP(w, r)
+}
+
+// cleanHost lower cases host and drops its port and any trailing dot, so
+// "Example.COM.:8080" and "example.com" are the same site
+func cleanHost(host string) string {
+	host = strings.TrimSpace(host)
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
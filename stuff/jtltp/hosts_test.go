@@ -0,0 +1,63 @@
+package jtltp
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestHostMux(t *testing.T) {
+	mux := NewHostMux()
+	mux.HandleFunc("blog.example.com", func(w ResponseWriter, r *Request) { w.SendGood("blog", "txt") })
+	mux.HandleFunc("*.example.com", func(w ResponseWriter, r *Request) { w.SendGood("example", "txt") })
+	mux.HandleFunc("localhost", func(w ResponseWriter, r *Request) { w.SendGood("local", "txt") })
+	address := startServer(t, &Server{Handler: mux})
+
+	tests := []struct {
+		host   string
+		status int
+		body   string
+	}{
+		{"blog.example.com", 200, "blog"},
+		{"BLOG.example.com.:8080", 200, "blog"},
+		{"shop.example.com", 200, "example"},
+		{"a.b.example.com", 200, "example"},
+		{"example.com", 404, "Not Found"},
+		{"other.org", 404, "Not Found"},
+	}
+	for _, tt := range tests {
+		req := NewRequest(MethodGet, "/", nil)
+		req.Header.Set("host", tt.host)
+		resp, _ := (&Client{}).Do(context.Background(), address, req)
+		if resp == nil || resp.Status != tt.status || string(resp.Body) != tt.body {
+			t.Errorf("%s: got %v, want %d %q", tt.host, resp, tt.status, tt.body)
+		}
+	}
+
+	// without a host header of its own, the client sends the name it dialed
+	_, port, _ := net.SplitHostPort(address)
+	resp, err := (&Client{}).Get(context.Background(), "jtltp://localhost:"+port+"/")
+	if err != nil || string(resp.Body) != "local" {
+		t.Errorf("Expected the localhost site, got %v, %v", resp, err)
+	}
+
+	// "*" catches unknown hosts and version 1 clients, which send none
+	mux.HandleFunc("*", func(w ResponseWriter, r *Request) { w.SendGood("default", "txt") })
+	req := NewRequest(MethodGet, "/", nil)
+	req.Header.Set("host", "other.org")
+	if resp, err := (&Client{}).Do(context.Background(), address, req); err != nil || string(resp.Body) != "default" {
+		t.Errorf("Expected the default site, got %v, %v", resp, err)
+	}
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("JTLTP-GET=[/]"))
+	reply, _ := io.ReadAll(conn)
+	if !strings.Contains(string(reply), "default") {
+		t.Errorf("Expected the default site for a version 1 client, got %q", reply)
+	}
+}
@@ -1,52 +1,33 @@
 package jtltp
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"net"
-	"regexp"
-	"fmt"
-	"strings"
-	"time"
 )
 
 // client
-// timeout takes a function and a timeout in milliseconds, times out the function if it takes too long and returns error
 
+// JtltpFetch asks the server at address for what using DefaultClient and
+// returns {"JTLTP-STATUS", "JTLTP-TYPE", "JTLTP"}. Non-200 answers come back
+// as a normal result rather than an error, as they always have; use a Client
+// to get the full Response with its headers.
 func JtltpFetch(address string, what string) (map[string]string, error) {
-	// connect to the server
-	conn, _ := net.Dial("tcp", address)
-
-	// send the request
-	timeoutnum := 30
-	conn.SetReadDeadline(time.Now().Add(time.Duration(timeoutnum) * time.Second))
-
-	// read the response
-	buffer := make([]byte, 1024)
-
-	conn.Write([]byte("JTLTP-GET=[" + what + "]"))
-	n, err := conn.Read(buffer)
-	if err != nil {
+	resp, err := DefaultClient.Fetch(context.Background(), address, what)
+	var statusErr *StatusError
+	if err != nil && !errors.As(err, &statusErr) {
 		return nil, err
 	}
-
-	// parse the response
-	message := string(buffer[:n])
-	// response format: JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP=MSG=["jtl document goes here"]
-	fmt.Println(message)
-	re_get := regexp.MustCompile(`JTLTP-STATUS=\[([0-9]+)\]\s+JTLTP-TYPE=\[([a-zA-Z0-9_-]+)\]\s+JTLTP=MSG=\[([^\]]+)\]`)
-	match := re_get.FindStringSubmatch(message)
-	if len(match) < 1 {
-		return nil, errors.New("bad response")
-	}
-
-	// return the message
-	return map[string]string{"JTLTP-STATUS": match[1], "JTLTP-TYPE": match[2], "JTLTP": match[3]}, nil
+	return resp.legacyMap(), nil
 }
 
 // server
 type jtltpServer struct {
 	listener   net.Listener
 	conn       net.Conn
+	reader     *bufio.Reader
+	legacy     bool // the current client speaks version 1
 	clientAddr string
 	demand     []string
 }
@@ -59,12 +40,13 @@ func NewJtltpServer(listener net.Listener, clientAddr string, demand []string) *
 	}
 }
 
-// ex JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP=MSG=["jtl document goes here"]
+// ex JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP-LENGTH=[24]\n"jtl document goes here"
 func (connection *jtltpServer) SendGood(message string, doctype string) {
+	conn, legacy := connection.conn, connection.legacy
 	go func() {
-		connection.conn.Write([]byte("JTLTP-STATUS=[200] JTLTP-TYPE=[" + doctype + "] JTLTP=MSG=[" + message + "]"))
+		connection.respond(conn, legacy, "200", doctype, message)
 		// disconnect
-		connection.conn.Close()
+		conn.Close()
 	}()
 
 	// move the connection to the next demand
@@ -74,10 +56,11 @@ func (connection *jtltpServer) SendGood(message string, doctype string) {
 }
 
 func (connection *jtltpServer) SendBad(message string, doctype string) {
+	conn, legacy := connection.conn, connection.legacy
 	go func() {
-		connection.conn.Write([]byte("JTLTP-STATUS=[400] JTLTP-TYPE=[" + doctype + "] JTLTP=MSG=[" + message + "]"))
+		connection.respond(conn, legacy, "400", doctype, message)
 		// disconnect
-		connection.conn.Close()
+		conn.Close()
 	}()
 
 	// move the connection to the next demand
@@ -87,10 +70,11 @@ func (connection *jtltpServer) SendBad(message string, doctype string) {
 }
 
 func (connection *jtltpServer) Send404() {
+	conn, legacy := connection.conn, connection.legacy
 	go func() {
-		connection.conn.Write([]byte("JTLTP-STATUS=[404] JTLTP-TYPE=[jtl] JTLTP=MSG=[Not Found]"))
+		connection.respond(conn, legacy, "404", "jtl", "Not Found")
 		// disconnect
-		connection.conn.Close()
+		conn.Close()
 	}()
 
 	// move the connection to the next demand
@@ -101,10 +85,11 @@ func (connection *jtltpServer) Send404() {
 
 // not really recommended
 func (connection *jtltpServer) SendRaw(message string, status string, msg string) {
+	conn, legacy := connection.conn, connection.legacy
 	go func() {
-		connection.conn.Write([]byte("JTLTP-STATUS=[" + status + "] JTLTP-TYPE=[" + msg + "] JTLTP=MSG=[" + message + "]"))
+		connection.respond(conn, legacy, status, msg, message)
 		// disconnect
-		connection.conn.Close()
+		conn.Close()
 	}()
 
 	// move the connection to the next demand
@@ -115,10 +100,11 @@ func (connection *jtltpServer) SendRaw(message string, status string, msg string
 
 // not really recommended at all
 func (connection *jtltpServer) SendRawer(message string) {
+	conn := connection.conn
 	go func() {
-		connection.conn.Write([]byte(message))
+		conn.Write([]byte(message))
 		// disconnect
-		connection.conn.Close()
+		conn.Close()
 	}()
 
 	// move the connection to the next demand
@@ -127,33 +113,59 @@ func (connection *jtltpServer) SendRawer(message string) {
 	}
 }
 
+// respond writes a response in whichever format the client asked in
+func (connection *jtltpServer) respond(conn net.Conn, legacy bool, status string, doctype string, message string) {
+	if legacy {
+		writeLegacyResponse(conn, status, doctype, []byte(message))
+		return
+	}
+	writeFrame(conn, &frame{
+		fields: []field{{fieldStatus, status}, {fieldType, doctype}},
+		body:   []byte(message),
+	})
+}
+
+// AwaitMessage reads the next request as {"JTLTP-GET": path}, or with the
+// POST/PUT verb as the key. AwaitRequest gives headers and body as well.
 func (connection *jtltpServer) AwaitMessage() map[string]string {
+	request := connection.AwaitRequest()
+	if request == nil {
+		return nil
+	}
+
+	// return the message
+	return map[string]string{"JTLTP-" + request.Method: request.Path}
+}
+
+// AwaitRequest reads the next request from the current connection
+func (connection *jtltpServer) AwaitRequest() *Request {
 	if connection.conn == nil {
 		return nil
 	}
 	// read the message
-	buffer := make([]byte, 1024)
-	n, err := connection.conn.Read(buffer)
+	framed, err := isFramed(connection.reader)
 	if err != nil {
 		return nil
 	}
-	// parse the message
-	message := string(buffer[:n])
-	if !strings.HasPrefix(message, "JTLTP-GET=[") {
-		return connection.AwaitMessage()
+	connection.legacy = !framed
+
+	var message *frame
+	if framed {
+		message, err = readFrame(connection.reader)
+	} else {
+		message, err = readLegacyRequest(connection.reader)
+	}
+	if err != nil {
+		return nil
 	}
 
-	re_get := regexp.MustCompile(`JTLTP-GET=\[(.+?)\]`)
-	match := re_get.FindStringSubmatch(message)
-	if len(match) < 2 {
+	request, err := requestFromFrame(message)
+	if err != nil {
 		connection.Send404()
 		return nil
 	}
-
-	what_to_get := match[1]
-
-	// return the message
-	return map[string]string{"JTLTP-GET": what_to_get}
+	request.RemoteAddr = connection.conn.RemoteAddr().String()
+	return request
 }
 
 func (connection *jtltpServer) AwaitConnection() error {
@@ -162,5 +174,7 @@ func (connection *jtltpServer) AwaitConnection() error {
 		return err
 	}
 	connection.conn = conn
+	connection.reader = bufio.NewReader(conn)
+	connection.legacy = false
 	return nil
 }
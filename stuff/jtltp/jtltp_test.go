@@ -1,7 +1,12 @@
 package jtltp
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"net"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 	"sync"
@@ -163,3 +168,173 @@ func TestJtltpServer(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestJtltpFetchLargeBody(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer listener.Close()
+
+	server := NewJtltpServer(listener, "localhost", []string{"big"})
+	body := ">type=\"lua\">script>print(t[1]);\n" + strings.Repeat("x]", 4096)
+
+	go func() {
+		if err := server.AwaitConnection(); err != nil {
+			t.Errorf("Server connection error: %v", err)
+			return
+		}
+		msg := server.AwaitMessage()
+		if msg["JTLTP-GET"] == "/pages/[big].jtl" {
+			server.SendGood(body, "jtl")
+		} else {
+			server.Send404()
+		}
+	}()
+
+	result, err := JtltpFetch(listener.Addr().String(), "/pages/[big].jtl")
+	if err != nil {
+		t.Fatalf("Failed to fetch document: %v", err)
+	}
+	if result["JTLTP-STATUS"] != "200" || result["JTLTP"] != body {
+		t.Errorf("Body was cut off or mangled: status %s, %d of %d bytes", result["JTLTP-STATUS"], len(result["JTLTP"]), len(body))
+	}
+}
+
+// v1Server is the version 1 server loop as it was before framing: one read
+// per message, anything not starting with JTLTP-GET=[ is skipped.
+type v1Server struct {
+	listener net.Listener
+	conn     net.Conn
+}
+
+func (s *v1Server) AwaitConnection() error {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *v1Server) AwaitMessage() map[string]string {
+	buffer := make([]byte, 1024)
+	n, err := s.conn.Read(buffer)
+	if err != nil {
+		return nil
+	}
+	message := string(buffer[:n])
+	if !strings.HasPrefix(message, "JTLTP-GET=[") {
+		return s.AwaitMessage()
+	}
+	match := regexp.MustCompile(`JTLTP-GET=\[(.+?)\]`).FindStringSubmatch(message)
+	if len(match) < 2 {
+		return nil
+	}
+	return map[string]string{"JTLTP-GET": match[1]}
+}
+
+func (s *v1Server) SendGood(message string, doctype string) {
+	s.conn.Write([]byte("JTLTP-STATUS=[200] JTLTP-TYPE=[" + doctype + "] JTLTP=MSG=[" + message + "]"))
+	s.conn.Close()
+}
+
+func TestClientLegacyServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer listener.Close()
+
+	server := &v1Server{listener: listener}
+	go func() {
+		for server.AwaitConnection() == nil {
+			msg := server.AwaitMessage()
+			if msg == nil {
+				server.conn.Close()
+				continue
+			}
+			server.SendGood("old [style] document for "+msg["JTLTP-GET"], "jtl")
+		}
+	}()
+
+	// the framed request goes unanswered until the read timeout, then it's
+	// sent again the version 1 way
+	client := &Client{ReadTimeout: 200 * time.Millisecond}
+	resp, err := client.Fetch(context.Background(), listener.Addr().String(), "test")
+	if err != nil {
+		t.Fatalf("Failed to fetch from legacy server: %v", err)
+	}
+	if result := resp.legacyMap(); result["JTLTP-STATUS"] != "200" || result["JTLTP"] != "old [style] document for test" {
+		t.Errorf("Unexpected result from legacy server: %v", result)
+	}
+
+	// the client remembers and doesn't wait for the timeout again
+	start := time.Now()
+	resp, err = client.Fetch(context.Background(), listener.Addr().String(), "again")
+	if err != nil || string(resp.Body) != "old [style] document for again" {
+		t.Errorf("Second fetch from legacy server: %v, %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed >= client.ReadTimeout {
+		t.Errorf("Second fetch took %v, the server's version wasn't remembered", elapsed)
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	sent := &frame{
+		fields: []field{{fieldGet, `/odd]path\with` + "\nnewline"}},
+		body:   []byte("binary\x00body]"),
+	}
+	if err := writeFrame(&buf, sent); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+
+	reader := bufio.NewReader(&buf)
+	framed, err := isFramed(reader)
+	if err != nil || !framed {
+		t.Fatalf("Expected a version marker, got framed=%v err=%v", framed, err)
+	}
+	got, err := readFrame(reader)
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	if what, _ := got.get(fieldGet); what != `/odd]path\with`+"\nnewline" {
+		t.Errorf("Field did not survive escaping: %q", what)
+	}
+	if !bytes.Equal(got.body, sent.body) {
+		t.Errorf("Body mismatch: %q", got.body)
+	}
+}
+
+func TestJtltpServerAwaitRequest(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer listener.Close()
+	server := NewJtltpServer(listener, "localhost", nil)
+
+	go func() {
+		if err := server.AwaitConnection(); err != nil {
+			t.Errorf("Server connection error: %v", err)
+			return
+		}
+		req := server.AwaitRequest()
+		if req == nil {
+			server.Send404()
+			return
+		}
+		server.SendGood(req.Method+":"+req.Header.Get("content-type")+":"+string(req.Body), "txt")
+	}()
+
+	req := NewRequest(MethodPost, "/form", []byte("name=james"))
+	req.Header.Set("Content-Type", "form")
+	resp, err := (&Client{}).Do(context.Background(), listener.Addr().String(), req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if string(resp.Body) != "POST:form:name=james" {
+		t.Errorf("Unexpected reply: %q", resp.Body)
+	}
+}
@@ -0,0 +1,101 @@
+package jtltp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeepAlive(t *testing.T) {
+	echoAddr := HandlerFunc(func(w ResponseWriter, r *Request) { w.SendGood(r.RemoteAddr, "txt") })
+	address := startServer(t, &Server{Handler: echoAddr, KeepAlive: true, IdleTimeout: 200 * time.Millisecond})
+
+	fetchAddr := func(client *Client) string {
+		t.Helper()
+		resp, err := client.Fetch(context.Background(), address, "/")
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		return string(resp.Body)
+	}
+
+	client := &Client{KeepAlive: true}
+	defer client.CloseIdleConnections()
+	first := fetchAddr(client)
+	for i := 0; i < 3; i++ {
+		if got := fetchAddr(client); got != first {
+			t.Errorf("Request %d used a new connection: %s, want %s", i, got, first)
+		}
+	}
+
+	// the server drops the idle connection, the client has to notice and redial
+	time.Sleep(400 * time.Millisecond)
+	if got := fetchAddr(client); got == first {
+		t.Error("Expected a new connection after the server's idle timeout")
+	}
+
+	if fetchAddr(&Client{}) == fetchAddr(&Client{}) {
+		t.Error("Clients without KeepAlive shared a connection")
+	}
+
+	short := &Client{KeepAlive: true, IdleTimeout: 50 * time.Millisecond}
+	fetchAddr(short)
+	time.Sleep(150 * time.Millisecond)
+	short.mu.Lock()
+	pooled := len(short.idle)
+	short.mu.Unlock()
+	if pooled != 0 {
+		t.Errorf("Expected the client to close idle connections, %d hosts still pooled", pooled)
+	}
+}
+
+func TestKeepAliveServerOff(t *testing.T) {
+	echoAddr := HandlerFunc(func(w ResponseWriter, r *Request) { w.SendGood(r.RemoteAddr, "txt") })
+	address := startServer(t, &Server{Handler: echoAddr})
+
+	client := &Client{KeepAlive: true}
+	var seen []string
+	for i := 0; i < 2; i++ {
+		resp, err := client.Fetch(context.Background(), address, "/")
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if resp.Header.Get("connection") != "" {
+			t.Errorf("Server without KeepAlive answered %q", resp.Header.Get("connection"))
+		}
+		seen = append(seen, string(resp.Body))
+	}
+	if seen[0] == seen[1] {
+		t.Error("Connection was reused although the server closes it")
+	}
+}
+
+func TestKeepAliveShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	server := &Server{KeepAlive: true, Handler: HandlerFunc(func(w ResponseWriter, r *Request) { w.SendGood("ok", "txt") })}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener) }()
+
+	client := &Client{KeepAlive: true}
+	defer client.CloseIdleConnections()
+	if _, err := client.Fetch(context.Background(), listener.Addr().String(), "/"); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	// the pooled connection sits idle on the server and must not block shutdown
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Expected ErrServerClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Idle kept-alive connection held up shutdown")
+	}
+}
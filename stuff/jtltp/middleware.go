@@ -0,0 +1,222 @@
+package jtltp
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a Handler with behaviour of its own, like Compress.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in middleware, the first one ending up outermost:
+//
+//	jtltp.Chain(mux, jtltp.LogRequests(nil), limit, jtltp.Compress)
+//
+// logs every request, including the ones limit turns away.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// LogRequests logs one line per request with its method, path, status, body
+// size, duration and client address. Nil means slog.Default().
+func LogRequests(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(h Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			h.ServeJTLTP(sw, r)
+			logger.Info("jtltp request",
+				"method", r.Method,
+				"path", r.Path,
+				"status", sw.status,
+				"bytes", sw.bytes,
+				"duration", time.Since(start),
+				"client", r.RemoteAddr,
+			)
+		})
+	}
+}
+
+// statusWriter remembers what a handler answered with
+type statusWriter struct {
+	ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) SendGood(message string, doctype string) {
+	w.Send(200, doctype, []byte(message))
+}
+
+func (w *statusWriter) SendBad(message string, doctype string) {
+	w.Send(400, doctype, []byte(message))
+}
+
+func (w *statusWriter) Send404() {
+	w.Send(404, "jtl", []byte("Not Found"))
+}
+
+func (w *statusWriter) Send(status int, doctype string, body []byte) {
+	if w.status == 0 {
+		w.status, w.bytes = status, len(body)
+	}
+	w.ResponseWriter.Send(status, doctype, body)
+}
+
+func (w *statusWriter) Stream(status int, doctype string) io.WriteCloser {
+	if w.status == 0 {
+		w.status = status
+	}
+	return &countingWriter{WriteCloser: Stream(w.ResponseWriter, status, doctype), n: &w.bytes}
+}
+
+type countingWriter struct {
+	io.WriteCloser
+	n *int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	*c.n += n
+	return n, err
+}
+
+// clientIP returns the address part of a RemoteAddr
+func clientIP(remoteAddr string) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// parsePrefixes reads addresses like "10.0.0.0/8", "::1" or "192.168.1.7"
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func matchesAny(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterIPs lets a client through if its address isn't in deny and, when
+// allow isn't empty, is in allow. Entries are single addresses or CIDR
+// ranges. Everyone else gets a 403.
+func FilterIPs(allow []string, deny []string) (Middleware, error) {
+	allowed, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, fmt.Errorf("jtltp: allow list: %w", err)
+	}
+	denied, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, fmt.Errorf("jtltp: deny list: %w", err)
+	}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			addr, err := clientIP(r.RemoteAddr)
+			if err != nil || matchesAny(denied, addr) || len(allowed) > 0 && !matchesAny(allowed, addr) {
+				w.Send(403, "txt", []byte("Forbidden"))
+				return
+			}
+			h.ServeJTLTP(w, r)
+		})
+	}, nil
+}
+
+// maxBuckets is how many clients RateLimit tracks before it forgets the ones
+// that are back to a full bucket
+const maxBuckets = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimit gives every client address a bucket of burst requests that
+// refills at perSecond. Requests finding the bucket empty get a 429.
+func RateLimit(perSecond float64, burst int) Middleware {
+	var mu sync.Mutex
+	buckets := map[netip.Addr]*bucket{}
+
+	// allow takes a token from addr's bucket if there is one
+	allow := func(addr netip.Addr, now time.Time) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(buckets) >= maxBuckets {
+			for other, b := range buckets {
+				if b.tokens+now.Sub(b.last).Seconds()*perSecond >= float64(burst) {
+					delete(buckets, other)
+				}
+			}
+		}
+
+		b, ok := buckets[addr]
+		if !ok {
+			b = &bucket{tokens: float64(burst), last: now}
+			buckets[addr] = b
+		}
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+		b.last = now
+		if b.tokens < 1 {
+			return false
+		}
+		b.tokens--
+		return true
+	}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			addr, err := clientIP(r.RemoteAddr)
+			if err == nil && !allow(addr, time.Now()) {
+				w.Send(429, "txt", []byte("Too Many Requests"))
+				return
+			}
+			h.ServeJTLTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package jtltp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// status fetches path and returns the status of the answer
+func status(t *testing.T, address string, path string) int {
+	t.Helper()
+	resp, err := (&Client{}).Fetch(context.Background(), address, path)
+	if resp == nil {
+		t.Fatalf("Fetch %s failed: %v", path, err)
+	}
+	return resp.Status
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(h Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, r *Request) {
+				order = append(order, name)
+				h.ServeJTLTP(w, r)
+			})
+		}
+	}
+	handler := Chain(HandlerFunc(func(w ResponseWriter, r *Request) {
+		order = append(order, "handler")
+		w.SendGood("ok", "txt")
+	}), tag("first"), tag("second"))
+	address := startServer(t, &Server{Handler: handler})
+
+	if got := status(t, address, "/"); got != 200 {
+		t.Fatalf("Expected 200, got %d", got)
+	}
+	if strings.Join(order, " ") != "first second handler" {
+		t.Errorf("Unexpected order: %v", order)
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(lockedWriter{&mu, &buf}, nil))
+
+	mux := NewServeMux()
+	mux.HandleFunc("/page.jtl", func(w ResponseWriter, r *Request) { w.SendGood("hello", "jtl") })
+	mux.HandleFunc("/stream.jtl", func(w ResponseWriter, r *Request) {
+		s := Stream(w, 200, "jtl")
+		s.Write([]byte("one"))
+		s.Write([]byte("two"))
+		s.Close()
+	})
+	address := startServer(t, &Server{Handler: Chain(mux, LogRequests(logger))})
+
+	status(t, address, "/page.jtl")
+	status(t, address, "/missing.jtl")
+	if _, err := (&Client{}).GetStream(context.Background(), "jtltp://"+address+"/stream.jtl", nil); err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []struct {
+		path   string
+		status int
+		bytes  int
+	}{
+		{"/page.jtl", 200, 5},
+		{"/missing.jtl", 404, 9},
+		{"/stream.jtl", 200, 6},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d log lines, got %q", len(want), lines)
+	}
+	for i, line := range lines {
+		var entry struct {
+			Msg    string
+			Method string
+			Path   string
+			Status int
+			Bytes  int
+			Client string
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Bad log line %q: %v", line, err)
+		}
+		if entry.Path != want[i].path || entry.Status != want[i].status || entry.Bytes != want[i].bytes || entry.Method != MethodGet {
+			t.Errorf("Line %d: got %+v, want %+v", i, entry, want[i])
+		}
+		if !strings.HasPrefix(entry.Client, "127.0.0.1:") {
+			t.Errorf("Line %d: unexpected client %q", i, entry.Client)
+		}
+	}
+}
+
+type lockedWriter struct {
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestFilterIPs(t *testing.T) {
+	ok := HandlerFunc(func(w ResponseWriter, r *Request) { w.SendGood("ok", "txt") })
+	tests := []struct {
+		allow []string
+		deny  []string
+		want  int
+	}{
+		{nil, nil, 200},
+		{[]string{"127.0.0.1"}, nil, 200},
+		{[]string{"127.0.0.0/8"}, nil, 200},
+		{[]string{"10.0.0.0/8"}, nil, 403},
+		{nil, []string{"127.0.0.1"}, 403},
+		{nil, []string{"::1", "192.168.0.0/16"}, 200},
+		{[]string{"127.0.0.0/8"}, []string{"127.0.0.1"}, 403},
+	}
+	for _, tt := range tests {
+		filter, err := FilterIPs(tt.allow, tt.deny)
+		if err != nil {
+			t.Fatalf("FilterIPs(%v, %v): %v", tt.allow, tt.deny, err)
+		}
+		address := startServer(t, &Server{Handler: filter(ok)})
+		if got := status(t, address, "/"); got != tt.want {
+			t.Errorf("allow %v deny %v: got %d, want %d", tt.allow, tt.deny, got, tt.want)
+		}
+	}
+
+	if _, err := FilterIPs([]string{"not an address"}, nil); err == nil {
+		t.Error("Expected an error for a bad allow entry")
+	}
+	if _, err := FilterIPs(nil, []string{"10.0.0.0/99"}); err == nil {
+		t.Error("Expected an error for a bad deny entry")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ok := HandlerFunc(func(w ResponseWriter, r *Request) { w.SendGood("ok", "txt") })
+	address := startServer(t, &Server{Handler: RateLimit(0.001, 2)(ok)})
+
+	for i, want := range []int{200, 200, 429, 429} {
+		if got := status(t, address, "/"); got != want {
+			t.Errorf("Request %d: got %d, want %d", i, got, want)
+		}
+	}
+
+	// a quick refill lets the next request through
+	address = startServer(t, &Server{Handler: RateLimit(1000, 1)(ok)})
+	for i := 0; i < 3; i++ {
+		time.Sleep(5 * time.Millisecond)
+		if got := status(t, address, "/"); got != 200 {
+			t.Errorf("Request %d: got %d, want 200", i, got)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package jtltp
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ServeMux routes requests by path, the same way net/http's ServeMux does:
+// a pattern like "/index.jtl" matches only that path, while a pattern ending
+// in a slash like "/scripts/" matches everything under it. The longest
+// matching pattern wins.
+type ServeMux struct {
+	mu       sync.RWMutex
+	exact    map[string]Handler
+	prefixes []muxEntry // sorted longest first
+}
+
+type muxEntry struct {
+	pattern string
+	handler Handler
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{exact: make(map[string]Handler)}
+}
+
+// Handle registers handler for pattern. It panics if the pattern is empty or
+// already registered.
+func (mux *ServeMux) Handle(pattern string, handler Handler) {
+	if pattern == "" {
+		panic("jtltp: empty pattern")
+	}
+	if handler == nil {
+		panic("jtltp: nil handler")
+	}
+	pattern = cleanPath(pattern)
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if mux.exact == nil {
+		mux.exact = make(map[string]Handler)
+	}
+	if _, exists := mux.exact[pattern]; exists {
+		panic("jtltp: multiple registrations for " + pattern)
+	}
+	mux.exact[pattern] = handler
+
+	if strings.HasSuffix(pattern, "/") {
+		mux.prefixes = append(mux.prefixes, muxEntry{pattern, handler})
+		sort.SliceStable(mux.prefixes, func(i, j int) bool {
+			return len(mux.prefixes[i].pattern) > len(mux.prefixes[j].pattern)
+		})
+	}
+}
+
+func (mux *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	mux.Handle(pattern, HandlerFunc(handler))
+}
+
+// Handler returns the handler for r and the pattern it was registered with.
+// If nothing matches it returns NotFoundHandler and an empty pattern.
+func (mux *ServeMux) Handler(r *Request) (Handler, string) {
+	p := cleanPath(r.Path)
+
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	if handler, ok := mux.exact[p]; ok {
+		return handler, p
+	}
+	for _, entry := range mux.prefixes {
+		if strings.HasPrefix(p, entry.pattern) {
+			return entry.handler, entry.pattern
+		}
+	}
+	return NotFoundHandler(), ""
+}
+
+func (mux *ServeMux) ServeJTLTP(w ResponseWriter, r *Request) {
+	handler, _ := mux.Handler(r)
+	handler.ServeJTLTP(w, r)
+}
+
+// cleanPath gives p a leading slash and removes . and .. elements, keeping a
+// trailing slash. Old clients ask for "test" rather than "/test".
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
@@ -0,0 +1,113 @@
+package jtltp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// errStaleConn means a pooled connection turned out to be closed by the
+// server before it answered, so the request can be sent again.
+var errStaleConn = errors.New("jtltp: stale pooled connection")
+
+// clientConn is a connection a Client can keep between requests
+type clientConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	key    string
+	reused bool
+	timer  *time.Timer // closes the connection once it idled too long
+}
+
+// failed classifies an error that happened before any of the response was
+// read. On a reused connection that usually means the server closed it while
+// it sat in the pool.
+func (cc *clientConn) failed(ctx context.Context, err error) error {
+	if cc.reused && ctx.Err() == nil &&
+		(errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)) {
+		return errStaleConn
+	}
+	return classify(ctx, err)
+}
+
+func poolKey(address string, tlsConfig *tls.Config) string {
+	if tlsConfig != nil {
+		return "tls:" + tlsConfig.ServerName + "@" + address
+	}
+	return address
+}
+
+// getIdle takes the most recently used idle connection for key
+func (c *Client) getIdle(key string) *clientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		cc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		c.idle[key] = conns
+		// the timer already fired if Stop fails, the connection is closed
+		if cc.timer.Stop() {
+			cc.reused = true
+			return cc
+		}
+	}
+	return nil
+}
+
+// putIdle pools cc, reporting false if the pool for its host is full
+func (c *Client) putIdle(cc *clientConn) bool {
+	maxIdle := c.MaxIdlePerHost
+	if maxIdle == 0 {
+		maxIdle = 2
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle[cc.key]) >= maxIdle {
+		return false
+	}
+	if c.idle == nil {
+		c.idle = make(map[string][]*clientConn)
+	}
+	c.idle[cc.key] = append(c.idle[cc.key], cc)
+	cc.timer = time.AfterFunc(durationOr(c.IdleTimeout, 90*time.Second), func() {
+		c.removeIdle(cc)
+		cc.conn.Close()
+	})
+	return true
+}
+
+func (c *Client) removeIdle(cc *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[cc.key]
+	for i, other := range conns {
+		if other == cc {
+			c.idle[cc.key] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(c.idle[cc.key]) == 0 {
+		delete(c.idle, cc.key)
+	}
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, cc := range conns {
+			if cc.timer.Stop() {
+				cc.conn.Close()
+			}
+		}
+		delete(c.idle, key)
+	}
+}
@@ -0,0 +1,144 @@
+package jtltp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Request verbs. A request carries its verb as the name of its path field,
+// JTLTP-GET=[/index.jtl] or JTLTP-POST=[/scores].
+const (
+	MethodGet  = "GET"
+	MethodPost = "POST"
+	MethodPut  = "PUT"
+
+	// MethodSubscribe keeps the connection open for messages pushed by the
+	// server, see Broadcaster and Client.Subscribe.
+	MethodSubscribe = "SUBSCRIBE"
+)
+
+var methods = []string{MethodGet, MethodPost, MethodPut, MethodSubscribe}
+
+// Header holds the key/value pairs sent along with a request or response.
+// Keys are stored lower case, on the wire they are fields that don't start
+// with JTLTP-.
+type Header map[string]string
+
+func (h Header) Get(key string) string {
+	return h[strings.ToLower(key)]
+}
+
+func (h Header) Set(key, value string) {
+	h[strings.ToLower(key)] = value
+}
+
+func (h Header) Del(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// fields returns h as frame fields, sorted so the output is stable
+func (h Header) fields() []field {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := make([]field, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, field{strings.ToLower(key), h[key]})
+	}
+	return fields
+}
+
+// headerFromFields collects the non-protocol fields of a frame
+func headerFromFields(fields []field) Header {
+	h := Header{}
+	for _, fl := range fields {
+		if !strings.HasPrefix(fl.name, "JTLTP-") {
+			h.Set(fl.name, fl.value)
+		}
+	}
+	return h
+}
+
+// Request is a JTLTP request, built by clients with NewRequest and handed to
+// a server's Handler with RemoteAddr filled in.
+type Request struct {
+	Method     string
+	Path       string
+	Header     Header
+	Body       []byte
+	RemoteAddr string
+
+	onChunk func(resp *Response, chunk []byte) // see Client.GetStream
+	url     string                             // set by Client.DoURL
+	ctx     context.Context                    // set by Server
+}
+
+// Context is cancelled when the server shuts down, or for subscriptions when
+// the client goes away.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// NewRequest returns a request for path with an empty header.
+func NewRequest(method string, path string, body []byte) *Request {
+	return &Request{
+		Method: method,
+		Path:   path,
+		Header: Header{},
+		Body:   body,
+	}
+}
+
+func validMethod(method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Request) toFrame() (*frame, error) {
+	method := r.Method
+	if method == "" {
+		method = MethodGet
+	}
+	if !validMethod(method) {
+		return nil, fmt.Errorf("jtltp: unknown method %q", method)
+	}
+	if (method == MethodGet || method == MethodSubscribe) && len(r.Body) > 0 {
+		return nil, fmt.Errorf("jtltp: %s request with a body", method)
+	}
+	return &frame{
+		fields: append([]field{{"JTLTP-" + method, r.Path}}, r.Header.fields()...),
+		body:   r.Body,
+	}, nil
+}
+
+// requestFromFrame finds the verb field of a request frame. A frame with no
+// verb, or more than one, is malformed.
+func requestFromFrame(f *frame) (*Request, error) {
+	req := &Request{Header: headerFromFields(f.fields), Body: f.body}
+	for _, fl := range f.fields {
+		method, ok := strings.CutPrefix(fl.name, "JTLTP-")
+		if !ok || !validMethod(method) {
+			continue
+		}
+		if req.Method != "" {
+			return nil, fmt.Errorf("%w: more than one verb", errMalformed)
+		}
+		req.Method, req.Path = method, fl.value
+	}
+	if req.Method == "" || req.Path == "" {
+		return nil, fmt.Errorf("%w: missing verb", errMalformed)
+	}
+	return req, nil
+}
@@ -0,0 +1,70 @@
+package jtltp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Response is a server's answer to a Request.
+type Response struct {
+	Status int
+	Type   string
+	Header Header
+	Body   []byte
+
+	// URL is the jtltp:// URL the response came from, after redirects. Only
+	// set by Client.Get and Client.DoURL.
+	URL string
+}
+
+// IsRedirect reports whether status sends the client elsewhere, to the URL
+// or path in the response's location header.
+func IsRedirect(status int) bool {
+	switch status {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+// Redirect answers with status and a location header pointing at location,
+// an absolute jtltp:// URL or a path on the same server.
+func Redirect(w ResponseWriter, location string, status int) {
+	if !IsRedirect(status) {
+		status = 302
+	}
+	w.Header().Set("location", location)
+	w.Send(status, "txt", []byte("Moved to "+location))
+}
+
+func (r *Response) toFrame() *frame {
+	fields := []field{{fieldStatus, strconv.Itoa(r.Status)}, {fieldType, r.Type}}
+	return &frame{
+		fields: append(fields, r.Header.fields()...),
+		body:   r.Body,
+	}
+}
+
+func responseFromFrame(f *frame) (*Response, error) {
+	statusStr, _ := f.get(fieldStatus)
+	status, err := strconv.Atoi(statusStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad status %q", ErrMalformedResponse, statusStr)
+	}
+	doctype, _ := f.get(fieldType)
+	return &Response{
+		Status: status,
+		Type:   doctype,
+		Header: headerFromFields(f.fields),
+		Body:   f.body,
+	}, nil
+}
+
+// legacyMap is the map JtltpFetch has always returned
+func (r *Response) legacyMap() map[string]string {
+	return map[string]string{
+		"JTLTP-STATUS": strconv.Itoa(r.Status),
+		"JTLTP-TYPE":   r.Type,
+		"JTLTP":        string(r.Body),
+	}
+}
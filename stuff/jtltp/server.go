@@ -0,0 +1,411 @@
+package jtltp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve after its context is cancelled.
+var ErrServerClosed = errors.New("jtltp: server closed")
+
+// ResponseWriter answers a single request. Headers set on Header before the
+// send go out with the response. Only the first send counts, later ones are
+// dropped.
+type ResponseWriter interface {
+	Header() Header
+	SendGood(message string, doctype string)
+	SendBad(message string, doctype string)
+	Send404()
+	Send(status int, doctype string, body []byte)
+}
+
+// Handler responds to a JTLTP request.
+type Handler interface {
+	ServeJTLTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc lets an ordinary function be used as a Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+func (f HandlerFunc) ServeJTLTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// NotFoundHandler answers every request with Send404.
+func NotFoundHandler() Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) { w.Send404() })
+}
+
+// Server accepts connections concurrently and hands each request to Handler.
+// The zero value is usable and answers everything with a 404.
+type Server struct {
+	Handler Handler
+
+	// ReadTimeout bounds how long a client may take to send its request.
+	// Zero means 30 seconds.
+	ReadTimeout time.Duration
+
+	// ErrorLog receives accept and handler errors. Nil means the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	// TLSConfig is the base configuration for ServeTLS.
+	TLSConfig *tls.Config
+
+	// KeepAlive lets clients that ask for it send several requests over one
+	// connection. Off by default, every connection then carries one request.
+	KeepAlive bool
+	// IdleTimeout is how long a kept-alive connection may wait for its next
+	// request. Zero means 60 seconds.
+	IdleTimeout time.Duration
+
+	mu      sync.Mutex
+	waiting map[net.Conn]struct{} // connections still reading their request
+	wg      sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address addr and serves handler until ctx
+// is cancelled.
+func ListenAndServe(ctx context.Context, addr string, handler Handler) error {
+	server := &Server{Handler: handler}
+	return server.ListenAndServe(ctx, addr)
+}
+
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// ListenAndServeTLS is ListenAndServe for jtltps:// clients, see ServeTLS.
+func (s *Server) ListenAndServeTLS(ctx context.Context, addr string, certFile string, keyFile string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(ctx, listener, certFile, keyFile)
+}
+
+// ServeTLS is Serve with every connection wrapped in TLS. The certificate and
+// key files are added to a copy of TLSConfig, they can be empty if TLSConfig
+// already has a certificate.
+func (s *Server) ServeTLS(ctx context.Context, listener net.Listener, certFile string, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		listener.Close()
+		return errors.New("jtltp: ServeTLS needs a certificate")
+	}
+	return s.Serve(ctx, tls.NewListener(listener, config))
+}
+
+// Serve accepts connections on listener until ctx is cancelled, then stops
+// accepting, drops clients that haven't sent a request yet (including idle
+// kept-alive connections), waits for the requests already being handled and
+// returns ErrServerClosed. The listener is closed on return.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+			s.dropWaiting()
+		case <-stop:
+		}
+	}()
+	defer listener.Close()
+
+	var tempDelay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				s.wg.Wait()
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// back off like net/http does on temporary accept errors
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else if tempDelay *= 2; tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				s.logf("jtltp: accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			s.wg.Wait()
+			return err
+		}
+		tempDelay = 0
+
+		s.wg.Add(1)
+		go s.serveConn(ctx, conn)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	readTimeout := s.ReadTimeout
+	if readTimeout == 0 {
+		readTimeout = 30 * time.Second
+	}
+	idleTimeout := s.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = 60 * time.Second
+	}
+
+	reader := bufio.NewReader(conn)
+	for first := true; ; first = false {
+		s.trackWaiting(conn, true)
+		if ctx.Err() != nil {
+			// shutdown started before we were tracked, nobody else will drop us
+			s.trackWaiting(conn, false)
+			return
+		}
+		if first {
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+		} else {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+
+		framed, err := isFramed(reader)
+		if err == nil && !first {
+			// the next request arrived, it gets the usual time to finish
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		var request *frame
+		if err == nil {
+			if framed {
+				request, err = readFrame(reader)
+			} else {
+				request, err = readLegacyRequest(reader)
+			}
+		}
+		if !s.trackWaiting(conn, false) || ctx.Err() != nil {
+			// shutting down, the client gets nothing
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+
+		w := &responseWriter{conn: conn, legacy: !framed, header: Header{}}
+		if errors.Is(err, errVersion) {
+			// answered in the version we speak, the peer may know it
+			w.Send(505, "txt", []byte("Version Not Supported"))
+			drain(conn, reader)
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, errMalformed) {
+				return
+			}
+			w.SendBad("Bad Request", "txt")
+			drain(conn, reader)
+			return
+		}
+
+		req, err := requestFromFrame(request)
+		if err != nil {
+			w.SendBad("Bad Request", "txt")
+			drain(conn, reader)
+			return
+		}
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.ctx = ctx
+
+		// version 1 clients always expect the connection to close
+		w.keepAlive = s.KeepAlive && framed && req.Header.Get("connection") == "keep-alive"
+		w.chunked = framed && req.Header.Get("te") == "chunked"
+		req.Header.Del("connection")
+
+		if req.Method == MethodSubscribe {
+			// a subscriber sends nothing more, so a read returning means it
+			// hung up. once the request is cancelled, writes get a second to
+			// finish so they can't block the handler forever
+			reqCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				reader.ReadByte()
+				cancel()
+			}()
+			context.AfterFunc(reqCtx, func() { conn.SetWriteDeadline(time.Now().Add(time.Second)) })
+			req.ctx = reqCtx
+			w.keepAlive = false
+			s.handle(w, req)
+			cancel()
+			return
+		}
+
+		s.handle(w, req)
+		if !w.keepAlive {
+			return
+		}
+	}
+}
+
+// drain reads what's left of a bad request before the connection closes.
+// Closing with unread bytes resets the connection, which can throw away the
+// answer before the client has read it.
+func drain(conn net.Conn, reader *bufio.Reader) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(io.Discard, io.LimitReader(reader, maxHeaderSize))
+}
+
+func (s *Server) handle(w *responseWriter, req *Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			s.logf("jtltp: panic serving %s %s: %v", req.RemoteAddr, req.Path, err)
+			w.keepAlive = false
+			w.Send(500, "txt", []byte("Internal Server Error"))
+		}
+	}()
+
+	handler := s.Handler
+	if handler == nil {
+		handler = NotFoundHandler()
+	}
+	handler.ServeJTLTP(w, req)
+
+	if !w.sent {
+		s.logf("jtltp: handler for %s sent no response", req.Path)
+		w.Send(500, "txt", []byte("Internal Server Error"))
+	}
+	if w.stream != nil {
+		// like a missing send, a stream left open is finished for the handler
+		if err := w.stream.Close(); err != nil {
+			w.keepAlive = false
+		}
+	}
+}
+
+// trackWaiting adds or removes conn from the set of connections that are
+// still reading. Removing reports whether conn was still tracked, i.e. not
+// dropped by a shutdown.
+func (s *Server) trackWaiting(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.waiting == nil {
+			s.waiting = make(map[net.Conn]struct{})
+		}
+		s.waiting[conn] = struct{}{}
+		return true
+	}
+	_, ok := s.waiting[conn]
+	delete(s.waiting, conn)
+	return ok
+}
+
+func (s *Server) dropWaiting() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.waiting {
+		conn.SetReadDeadline(time.Now())
+		delete(s.waiting, conn)
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// responseWriter is the ResponseWriter handed to handlers by Server
+type responseWriter struct {
+	conn      net.Conn
+	legacy    bool
+	header    Header
+	sent      bool
+	keepAlive bool // answer with connection=keep-alive and read another request
+	chunked   bool // the client reads chunked bodies
+	stream    *chunkWriter
+}
+
+func (w *responseWriter) Header() Header {
+	return w.header
+}
+
+func (w *responseWriter) SendGood(message string, doctype string) {
+	w.Send(200, doctype, []byte(message))
+}
+
+func (w *responseWriter) SendBad(message string, doctype string) {
+	w.Send(400, doctype, []byte(message))
+}
+
+func (w *responseWriter) Send404() {
+	w.Send(404, "jtl", []byte("Not Found"))
+}
+
+func (w *responseWriter) Send(status int, doctype string, body []byte) {
+	if w.sent {
+		return
+	}
+	w.sent = true
+
+	if w.legacy {
+		// version 1 has nowhere to put headers
+		writeLegacyResponse(w.conn, strconv.Itoa(status), doctype, body)
+		return
+	}
+	w.setConnection()
+	resp := &Response{Status: status, Type: doctype, Header: w.header, Body: body}
+	writeFrame(w.conn, resp.toFrame())
+}
+
+// Stream sends the response header right away and returns a writer for the
+// body, see the Stream function. Clients that didn't send te=[chunked] get
+// the body in one piece when it's closed.
+func (w *responseWriter) Stream(status int, doctype string) io.WriteCloser {
+	if w.sent || !w.chunked {
+		return &bufferedStream{w: w, status: status, doctype: doctype}
+	}
+	w.sent = true
+
+	w.setConnection()
+	w.header.Set("transfer-encoding", "chunked")
+	resp := &Response{Status: status, Type: doctype, Header: w.header}
+	w.stream = &chunkWriter{conn: w.conn}
+	if err := writeFrame(w.conn, resp.toFrame()); err != nil {
+		w.stream.closed = true
+		w.keepAlive = false
+	}
+	return w.stream
+}
+
+func (w *responseWriter) setConnection() {
+	if w.keepAlive {
+		w.header.Set("connection", "keep-alive")
+	} else {
+		w.header.Del("connection")
+	}
+}
@@ -0,0 +1,234 @@
+package jtltp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer runs server on a loopback listener until the test ends.
+func startServer(t testing.TB, server *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+func TestServerConcurrentClients(t *testing.T) {
+	const clients = 5
+	var arrived sync.WaitGroup
+	arrived.Add(clients)
+
+	// every handler blocks until all clients are being served at once
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		arrived.Done()
+		arrived.Wait()
+		w.SendGood("hello "+r.Path, "txt")
+	})}
+	address := startServer(t, server)
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := JtltpFetch(address, "/page")
+			if err != nil {
+				t.Errorf("Fetch failed: %v", err)
+				return
+			}
+			if result["JTLTP"] != "hello /page" {
+				t.Errorf("Unexpected body: %q", result["JTLTP"])
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Clients were not served concurrently")
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleFunc("/index.jtl", func(w ResponseWriter, r *Request) { w.SendGood("index", "jtl") })
+	mux.HandleFunc("/scripts/", func(w ResponseWriter, r *Request) { w.SendGood("scripts", "lua") })
+	mux.HandleFunc("/scripts/vendor/", func(w ResponseWriter, r *Request) { w.SendGood("vendor", "lua") })
+	address := startServer(t, &Server{Handler: mux})
+
+	tests := []struct {
+		path   string
+		status string
+		body   string
+	}{
+		{"/index.jtl", "200", "index"},
+		{"index.jtl", "200", "index"},
+		{"/scripts/main.lua", "200", "scripts"},
+		{"/scripts/vendor/lib.lua", "200", "vendor"},
+		{"/scripts/../index.jtl", "200", "index"},
+		{"/index.jtl/more", "404", "Not Found"},
+		{"/missing.jtl", "404", "Not Found"},
+	}
+	for _, tt := range tests {
+		result, err := JtltpFetch(address, tt.path)
+		if err != nil {
+			t.Errorf("%s: fetch failed: %v", tt.path, err)
+			continue
+		}
+		if result["JTLTP-STATUS"] != tt.status || result["JTLTP"] != tt.body {
+			t.Errorf("%s: got %s %q, want %s %q", tt.path, result["JTLTP-STATUS"], result["JTLTP"], tt.status, tt.body)
+		}
+	}
+}
+
+func TestServerGracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		close(started)
+		<-release
+		w.SendGood("finished", "txt")
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener) }()
+
+	// an idle client that never sends a request must not hold up shutdown
+	idle, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer idle.Close()
+
+	fetched := make(chan map[string]string, 1)
+	go func() {
+		result, err := JtltpFetch(listener.Addr().String(), "/slow")
+		if err != nil {
+			t.Errorf("In-flight request failed: %v", err)
+		}
+		fetched <- result
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case <-done:
+		t.Fatal("Serve returned before the in-flight request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+	if result := <-fetched; result["JTLTP"] != "finished" {
+		t.Errorf("In-flight request was not completed: %v", result)
+	}
+
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("Listener still accepting after shutdown")
+	}
+}
+
+func TestServerRequestVerbs(t *testing.T) {
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.SendGood(r.Method+" "+r.Path+" "+r.Header.Get("X-Game")+" "+string(r.Body), "txt")
+	})}
+	address := startServer(t, server)
+	client := &Client{}
+
+	tests := []struct {
+		method string
+		body   string
+		want   string
+	}{
+		{MethodGet, "", "GET /scores snake "},
+		{MethodPost, "score=42]\nmore", "POST /scores snake score=42]\nmore"},
+		{MethodPut, "score=43", "PUT /scores snake score=43"},
+	}
+	for _, tt := range tests {
+		req := NewRequest(tt.method, "/scores", []byte(tt.body))
+		req.Header.Set("x-game", "snake")
+		resp, err := client.Do(context.Background(), address, req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.method, err)
+			continue
+		}
+		if string(resp.Body) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.method, resp.Body, tt.want)
+		}
+	}
+
+	if _, err := client.Do(context.Background(), address, NewRequest("DELETE", "/scores", nil)); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+
+	// a frame with two verbs is rejected before reaching the handler
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("JTLTP-VERSION=[2] JTLTP-GET=[/a] JTLTP-POST=[/b] JTLTP-LENGTH=[0]\n"))
+	reply, err := readFrame(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if status, _ := reply.get(fieldStatus); status != "400" {
+		t.Errorf("Expected 400 for two verbs, got %s", status)
+	}
+}
+
+func TestServerResponseHeaders(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00]")
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("set-state", "theme=dark]")
+		w.Send(200, "png", png)
+	})}
+	address := startServer(t, server)
+
+	resp, err := (&Client{}).Fetch(context.Background(), address, "/logo.png")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.Status != 200 || resp.Type != "png" || !bytes.Equal(resp.Body, png) {
+		t.Errorf("Unexpected response: %d %s %q", resp.Status, resp.Type, resp.Body)
+	}
+	if resp.Header.Get("cache-control") != "max-age=60" || resp.Header.Get("Set-State") != "theme=dark]" {
+		t.Errorf("Headers did not arrive: %v", resp.Header)
+	}
+	for name := range resp.Header {
+		if strings.HasPrefix(name, "JTLTP-") {
+			t.Errorf("Protocol field %s leaked into the headers", name)
+		}
+	}
+}
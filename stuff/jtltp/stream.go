@@ -0,0 +1,149 @@
+package jtltp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Streamer is implemented by ResponseWriters that can send a body in pieces.
+// Use the Stream function rather than asserting it directly.
+type Streamer interface {
+	Stream(status int, doctype string) io.WriteCloser
+}
+
+// Stream starts a response whose body is written in pieces, each Write going
+// out as a chunk the client can use before the rest arrives. Close ends the
+// body. If the client can't read chunks, or w can't stream, the writes are
+// collected and sent as one response on Close.
+func Stream(w ResponseWriter, status int, doctype string) io.WriteCloser {
+	if s, ok := w.(Streamer); ok {
+		return s.Stream(status, doctype)
+	}
+	return &bufferedStream{w: w, status: status, doctype: doctype}
+}
+
+// bufferedStream sends everything written to it as one response on Close
+type bufferedStream struct {
+	w       ResponseWriter
+	status  int
+	doctype string
+	body    []byte
+	closed  bool
+}
+
+func (s *bufferedStream) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("jtltp: write to closed stream")
+	}
+	s.body = append(s.body, p...)
+	return len(p), nil
+}
+
+func (s *bufferedStream) Close() error {
+	if !s.closed {
+		s.closed = true
+		s.w.Send(s.status, s.doctype, s.body)
+	}
+	return nil
+}
+
+// chunkWriter writes a chunked body to the connection. The response header
+// goes out with transfer-encoding=[chunked] and an empty body, then every
+// Write is a frame holding just that piece, and an empty frame ends it.
+type chunkWriter struct {
+	conn   net.Conn
+	closed bool
+}
+
+func (s *chunkWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("jtltp: write to closed stream")
+	}
+	if len(p) == 0 {
+		// an empty chunk would end the body
+		return 0, nil
+	}
+	if err := writeFrame(s.conn, &frame{body: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *chunkWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return writeFrame(s.conn, &frame{})
+}
+
+// readChunks reads a chunked body into resp.Body, handing each piece to
+// onChunk if it's set. Every chunk gets readTimeout to arrive, so a slow but
+// steady stream never times out. The messages of a subscription may be any
+// time apart and aren't collected in resp.Body.
+func readChunks(ctx context.Context, conn net.Conn, r *bufio.Reader, readTimeout time.Duration, resp *Response, onChunk func(resp *Response, chunk []byte), subscription bool) error {
+	for {
+		if subscription {
+			conn.SetReadDeadline(time.Time{})
+		} else {
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		if ctx.Err() != nil {
+			// cancelled while we moved the deadline away from now
+			return ctx.Err()
+		}
+
+		chunk, err := readFrame(r)
+		if err != nil {
+			return classify(ctx, err)
+		}
+		if len(chunk.body) == 0 {
+			resp.Header.Del("transfer-encoding")
+			return nil
+		}
+		if !subscription {
+			if len(resp.Body)+len(chunk.body) > MaxBodySize {
+				return fmt.Errorf("%w: chunked body over %d bytes", ErrMalformedResponse, MaxBodySize)
+			}
+			resp.Body = append(resp.Body, chunk.body...)
+		}
+		if onChunk != nil {
+			onChunk(resp, chunk.body)
+		}
+	}
+}
+
+// GetStream is Get for documents that are worth using before they are
+// complete. onChunk is called on the calling goroutine for each piece of a
+// 200 answer's body as it arrives; resp has the status, type, headers and
+// URL, and the body received so far. Answers that aren't chunked, including
+// ones from the cache, come in a single piece.
+func (c *Client) GetStream(ctx context.Context, rawurl string, onChunk func(resp *Response, chunk []byte)) (*Response, error) {
+	req := NewRequest(MethodGet, "", nil)
+	req.onChunk = onChunk
+	return c.DoURL(ctx, rawurl, req)
+}
+
+// deliver hands a whole body to the request's onChunk, for answers that
+// weren't streamed. A subscription's messages only ever come as chunks.
+func (r *Request) deliver(resp *Response) {
+	if r.onChunk != nil && resp != nil && resp.Status == 200 && r.Method != MethodSubscribe {
+		resp.URL = r.url
+		r.onChunk(resp, resp.Body)
+	}
+}
+
+// Subscribe opens a subscription at rawurl and calls onMessage on the calling
+// goroutine for every message the server pushes. It returns nil when the
+// server ends the subscription, and ctx.Err() once ctx is cancelled.
+func (c *Client) Subscribe(ctx context.Context, rawurl string, onMessage func(message []byte)) error {
+	req := NewRequest(MethodSubscribe, "", nil)
+	req.onChunk = func(resp *Response, chunk []byte) { onMessage(chunk) }
+	_, err := c.DoURL(ctx, rawurl, req)
+	return err
+}
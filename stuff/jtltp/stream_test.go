@@ -0,0 +1,103 @@
+package jtltp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	received := make(chan string, 10)
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		stream := Stream(w, 200, "jtl")
+		stream.Write([]byte("first "))
+		// the rest only comes after the client saw the first piece
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+		}
+		stream.Write([]byte("second"))
+		stream.Close()
+	})
+	// Compress leaves streams alone for clients that don't want them compressed
+	address := startServer(t, &Server{Handler: Compress(handler), KeepAlive: true})
+
+	var chunks []string
+	client := &Client{KeepAlive: true, DisableCompression: true}
+	defer client.CloseIdleConnections()
+	start := time.Now()
+	resp, err := client.GetStream(context.Background(), "jtltp://"+address+"/page.jtl", func(resp *Response, chunk []byte) {
+		if resp.URL != "jtltp://"+address+"/page.jtl" || resp.Type != "jtl" {
+			t.Errorf("Unexpected response alongside chunk: %s %s", resp.URL, resp.Type)
+		}
+		chunks = append(chunks, string(chunk))
+		received <- string(chunk)
+	})
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Chunks were not delivered as they arrived")
+	}
+	if strings.Join(chunks, "|") != "first |second" || string(resp.Body) != "first second" {
+		t.Errorf("Unexpected chunks %q, body %q", chunks, resp.Body)
+	}
+	if resp.Header.Get("transfer-encoding") != "" {
+		t.Error("transfer-encoding leaked into the response headers")
+	}
+
+	// plain requests get the streamed body in full, over the same connection
+	resp, err = client.Fetch(context.Background(), address, "/page.jtl")
+	if err != nil || string(resp.Body) != "first second" {
+		t.Errorf("Fetch of a streamed body: %v, %v", resp, err)
+	}
+
+	// a client that doesn't send te=[chunked] gets one ordinary frame
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	received <- "go ahead"
+	conn.Write([]byte("JTLTP-VERSION=[2] JTLTP-GET=[/page.jtl] JTLTP-LENGTH=[0]\n"))
+	reply, err := readFrame(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if string(reply.body) != "first second" {
+		t.Errorf("Expected the whole body in one frame, got %q", reply.body)
+	}
+}
+
+func TestFileServerStream(t *testing.T) {
+	root := t.TempDir()
+	page := bytes.Repeat([]byte(">id=\"row\">p>streamed;\n"), 10000)
+	os.WriteFile(filepath.Join(root, "big.jtl"), page, 0o644)
+	address := startServer(t, &Server{Handler: FileServer(root)})
+
+	pieces := 0
+	resp, err := (&Client{}).GetStream(context.Background(), "jtltp://"+address+"/big.jtl", func(resp *Response, chunk []byte) {
+		pieces++
+	})
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+	if !bytes.Equal(resp.Body, page) {
+		t.Errorf("Body differs: got %d bytes, want %d", len(resp.Body), len(page))
+	}
+	if want := (len(page) + fileChunkSize - 1) / fileChunkSize; pieces != want {
+		t.Errorf("Got %d pieces, want %d", pieces, want)
+	}
+
+	// non-200 answers are not handed out in pieces
+	pieces = 0
+	if _, err := (&Client{}).GetStream(context.Background(), "jtltp://"+address+"/missing.jtl", func(*Response, []byte) { pieces++ }); err == nil || pieces != 0 {
+		t.Errorf("Expected an error and no pieces for a 404, got %v and %d", err, pieces)
+	}
+}
@@ -0,0 +1,441 @@
+package jtltp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// JTL is a piece of markup that templates insert as it is, like a fragment
+// rendered by another template. Never build one from user data.
+type JTL string
+
+// Template is a text/template for JTL documents. Every {{.Value}} is escaped
+// for where it lands in the document, so data can't add elements, end one
+// early or break out of an attribute or an ENV declaration:
+//
+//	>>>ENV;
+//	    >>>TITLE={{.Title}};
+//	>>>BEGIN;
+//	    >class="{{.Class}}">p>{{.Text}};
+//
+// JTL has no escape sequences, so the characters that would do harm are
+// swapped for ones that look alike: > becomes › (U+203A), " becomes ', and a
+// ; that would end a declaration or element line becomes ; (U+037E). Values
+// of type JTL are inserted unchanged.
+//
+// Variables, range, if and with work as in text/template, and
+// {{template "header.jtl" .}} includes another template of the same set,
+// escaped for the place it's included in. Every way through an if, range or
+// with has to end in the same place, a range body where it started, or
+// executing the template fails: the values after it couldn't be escaped.
+type Template struct {
+	text *template.Template
+
+	mu       sync.Mutex
+	err      error // why the set can't be escaped
+	escaped  map[*parse.Tree]bool
+	pristine map[string]*parse.Tree // templates as parsed, before any escaping
+	called   map[string]jtlContext  // where the copies made for a call end
+}
+
+// Names of the escapers added to the end of every action.
+const (
+	escaperText = "_jtl_escape_text"
+	escaperLine = "_jtl_escape_line"
+	escaperAttr = "_jtl_escape_attr"
+	escaperEnv  = "_jtl_escape_env"
+)
+
+var escapers = template.FuncMap{
+	escaperText: escapeText,
+	escaperLine: escapeLine,
+	escaperAttr: escapeAttr,
+	escaperEnv:  escapeEnv,
+}
+
+// NewTemplate returns an empty template called name.
+func NewTemplate(name string) *Template {
+	return &Template{
+		text:     template.New(name).Funcs(escapers),
+		escaped:  make(map[*parse.Tree]bool),
+		pristine: make(map[string]*parse.Tree),
+		called:   make(map[string]jtlContext),
+	}
+}
+
+// ParseTemplateFiles reads the named files into one set, each template named
+// after its file's base name. The first one is the set's own template.
+func ParseTemplateFiles(filenames ...string) (*Template, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("jtltp: no template files named")
+	}
+	return NewTemplate(filepath.Base(filenames[0])).ParseFiles(filenames...)
+}
+
+// ParseTemplateGlob is ParseTemplateFiles for the files matching pattern.
+func ParseTemplateGlob(pattern string) (*Template, error) {
+	filenames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("jtltp: no files match %q", pattern)
+	}
+	return ParseTemplateFiles(filenames...)
+}
+
+// Funcs adds functions for the template actions to call. It must be called
+// before Parse.
+func (t *Template) Funcs(funcs template.FuncMap) *Template {
+	t.text.Funcs(funcs)
+	return t
+}
+
+// Parse reads text as the template's body, its {{define}}s join the set.
+func (t *Template) Parse(text string) (*Template, error) {
+	if _, err := t.text.Parse(text); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseFiles adds the named files to the set, named after their base names.
+func (t *Template) ParseFiles(filenames ...string) (*Template, error) {
+	if _, err := t.text.ParseFiles(filenames...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Name returns the name of the template.
+func (t *Template) Name() string {
+	return t.text.Name()
+}
+
+// Execute renders the template with data into w.
+func (t *Template) Execute(w io.Writer, data any) error {
+	if err := t.escape(); err != nil {
+		return err
+	}
+	return t.text.Execute(w, data)
+}
+
+// ExecuteTemplate renders the template called name in t's set.
+func (t *Template) ExecuteTemplate(w io.Writer, name string, data any) error {
+	if err := t.escape(); err != nil {
+		return err
+	}
+	return t.text.ExecuteTemplate(w, name, data)
+}
+
+// Render sends the template called name, rendered with data, as a jtl
+// document. If rendering fails the client gets a 500 and the error is
+// returned, nothing half rendered is sent.
+func (t *Template) Render(w ResponseWriter, name string, data any) error {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		w.Send(500, "txt", []byte("Internal Server Error"))
+		return err
+	}
+	w.SendGood(buf.String(), "jtl")
+	return nil
+}
+
+// escape adds the escapers to every template of the set that hasn't had them
+// yet
+func (t *Template) escape() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+
+	// keep a copy of every new template before escaping any, {{template}}
+	// calls escape copies of them for their own context
+	var fresh []*template.Template
+	for _, tmpl := range t.text.Templates() {
+		if tmpl.Tree == nil || tmpl.Tree.Root == nil || t.escaped[tmpl.Tree] {
+			continue
+		}
+		t.pristine[tmpl.Name()] = tmpl.Tree.Copy()
+		fresh = append(fresh, tmpl)
+	}
+	for _, tmpl := range fresh {
+		(&jtlContext{tmpl: t, tree: tmpl.Tree}).walk(tmpl.Tree.Root)
+		t.escaped[tmpl.Tree] = true
+	}
+	return t.err
+}
+
+// call points a {{template}} call at a copy of the template escaped for the
+// context of the call, and returns the context the copy ends in
+func (t *Template) call(node *parse.TemplateNode, c jtlContext) (jtlContext, bool) {
+	pristine := t.pristine[node.Name]
+	if pristine == nil {
+		// not defined (yet), executing it fails anyway
+		return c, false
+	}
+	name := fmt.Sprintf("%s$jtl%d_%t_%t_%d", node.Name, c.state, c.element, c.open, c.last)
+	node.Name = name
+	if end, ok := t.called[name]; ok {
+		return end, true
+	}
+
+	// a template calling itself continues as if it printed a value
+	guess := c
+	guess.value()
+	t.called[name] = guess
+
+	tree := pristine.Copy()
+	tree.Name = name
+	end := c
+	end.tree = tree
+	end.walk(tree.Root)
+	end.tree = c.tree
+	if _, err := t.text.AddParseTree(name, tree); err != nil {
+		return guess, true
+	}
+	t.escaped[tree] = true
+	t.called[name] = end
+	return end, true
+}
+
+// fail records the first reason the set can't be escaped
+func (t *Template) fail(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// Where in a JTL line a value is inserted.
+const (
+	stateLineStart = iota // nothing but indentation yet
+	stateAttrs            // >class="a" id="b"> outside the quotes
+	stateAttrValue        // between the quotes of an attribute
+	stateKey              // the element type, between the separators
+	stateText             // element content, or any other text
+	stateEnvName          // >>>NAME before the =
+	stateEnvValue         // >>>NAME=value before the ;
+)
+
+// jtlContext follows the template text the way the JTL parser will read it
+type jtlContext struct {
+	tmpl    *Template
+	tree    *parse.Tree // the template being walked
+	state   int
+	element bool // the line belongs to an element
+	open    bool // the element continues on the next line, its line didn't end in ;
+	last    byte // last character of the line that isn't a space
+}
+
+func (c *jtlContext) walk(node parse.Node) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			c.walk(n)
+		}
+	case *parse.TextNode:
+		c.text(node.Text)
+	case *parse.ActionNode:
+		// {{$x := ...}} prints nothing
+		if len(node.Pipe.Decl) == 0 {
+			node.Pipe.Cmds = append(node.Pipe.Cmds, escaperCommand(c.escaper(), node.Position()))
+		}
+		c.value()
+	case *parse.IfNode:
+		c.branches(&node.BranchNode, false)
+	case *parse.RangeNode:
+		c.branches(&node.BranchNode, true)
+	case *parse.WithNode:
+		c.branches(&node.BranchNode, false)
+	case *parse.TemplateNode:
+		// the included template is escaped where it's included
+		if end, ok := c.tmpl.call(node, *c); ok {
+			*c = end
+		} else {
+			c.value()
+		}
+	}
+}
+
+// branches walks both sides of an if, range or with from the same context.
+// Without an else the other side is skipping the body, and a loop's body runs
+// again from where it ended, so that has to be where it started.
+func (c *jtlContext) branches(node *parse.BranchNode, loop bool) {
+	start := *c
+	c.walk(node.List)
+	other := start
+	if node.ElseList != nil {
+		other.walk(node.ElseList)
+	}
+	if !c.same(other) || loop && !c.same(start) {
+		location, _ := c.tree.ErrorContext(node)
+		c.tmpl.fail(fmt.Errorf("jtltp: %s: branches end in different contexts", location))
+	}
+}
+
+// same says if the document goes on the same way from c and other
+func (c *jtlContext) same(other jtlContext) bool {
+	return c.state == other.state && c.element == other.element && c.open == other.open &&
+		(c.last == ';') == (other.last == ';')
+}
+
+func escaperCommand(name string, pos parse.Pos) *parse.CommandNode {
+	return &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      pos,
+		Args:     []parse.Node{parse.NewIdentifier(name).SetPos(pos)},
+	}
+}
+
+func (c *jtlContext) escaper() string {
+	switch c.state {
+	case stateAttrs, stateAttrValue, stateKey, stateEnvName:
+		return escaperAttr
+	case stateEnvValue:
+		return escaperEnv
+	case stateLineStart:
+		return escaperLine
+	}
+	return escaperText
+}
+
+// value moves past an escaped value, which never changes the structure
+func (c *jtlContext) value() {
+	if c.state == stateLineStart {
+		c.state, c.element = stateText, c.open
+	}
+	c.last = 'x'
+}
+
+func (c *jtlContext) text(text []byte) {
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if ch == '\n' {
+			if c.element {
+				c.open = c.last != ';'
+			}
+			c.state, c.element, c.last = stateLineStart, false, 0
+			continue
+		}
+		if ch != ' ' && ch != '\t' && ch != '\r' {
+			c.last = ch
+		}
+
+		switch c.state {
+		case stateLineStart:
+			switch {
+			case ch == ' ' || ch == '\t' || ch == '\r':
+			case c.open:
+				c.state, c.element = stateText, true
+			case ch != '>':
+				c.state = stateText
+			case bytes.HasPrefix(text[i:], []byte(">>>")):
+				c.state = stateEnvName
+				i += 2
+			default:
+				c.state, c.element = stateAttrs, true
+			}
+		case stateAttrs:
+			switch ch {
+			case '"':
+				c.state = stateAttrValue
+			case '>':
+				c.state = stateKey
+			}
+		case stateAttrValue:
+			if ch == '"' {
+				c.state = stateAttrs
+			}
+		case stateKey:
+			if ch == '>' {
+				c.state = stateText
+			}
+		case stateEnvName:
+			if ch == '=' {
+				c.state = stateEnvValue
+			}
+		case stateEnvValue:
+			if ch == ';' {
+				c.state = stateEnvName
+			}
+		}
+	}
+}
+
+// stringify prints the result of an action the way text/template would,
+// reporting whether it's JTL that needs no escaping
+func stringify(args []any) (string, bool) {
+	if len(args) == 1 {
+		switch arg := args[0].(type) {
+		case JTL:
+			return string(arg), true
+		case string:
+			return arg, false
+		case nil:
+			return "", false
+		}
+	}
+	return fmt.Sprint(args...), false
+}
+
+// escapeText keeps a value inside the content of an element: no line it
+// starts may begin with >, and none may end with ;
+func escapeText(args ...any) string {
+	return escapeLines(args, 1)
+}
+
+// escapeLine is escapeText for values at the start of a line
+func escapeLine(args ...any) string {
+	return escapeLines(args, 0)
+}
+
+func escapeLines(args []any, from int) string {
+	s, safe := stringify(args)
+	if safe {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if start := len(line) - len(strings.TrimLeft(line, " \t\r")); i >= from && start < len(line) && line[start] == '>' {
+			line = line[:start] + "›" + line[start+1:]
+		}
+		if end := len(strings.TrimRight(line, " \t\r")); end > 0 && line[end-1] == ';' {
+			line = line[:end-1] + ";" + line[end:]
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+var attrReplacer = strings.NewReplacer(`"`, "'", ">", "›", "\r\n", " ", "\n", " ", "\r", " ")
+
+// escapeAttr keeps a value inside an attribute or an element type
+func escapeAttr(args ...any) string {
+	s, safe := stringify(args)
+	if safe {
+		return s
+	}
+	return attrReplacer.Replace(s)
+}
+
+var envReplacer = strings.NewReplacer(";", ";", "\r\n", " ", "\n", " ", "\r", " ")
+
+// escapeEnv keeps a value inside an ENV declaration
+func escapeEnv(args ...any) string {
+	s, safe := stringify(args)
+	if safe {
+		return s
+	}
+	return envReplacer.Replace(s)
+}
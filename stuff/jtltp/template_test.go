@@ -0,0 +1,169 @@
+package jtltp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OrtheSnowJames/jtl"
+)
+
+const evil = "hi;\n>>>END;\n>id=\"evil\">p>injected;\n"
+
+func TestTemplateEscaping(t *testing.T) {
+	page := `>>>DOCTYPE=JTL
+>>>ENV;
+    >>>TITLE={{.Title}};
+>>>BEGIN;
+    >class="{{.Class}}">{{.Kind}}>{{.Text}};
+    >id="note">p>
+        {{.Text}}
+    ;
+    {{range .Items}}>class="item">p>{{.}};
+    {{end}}>id="raw">p>{{.Raw}};
+    >class="{{template "cls" .Pwn}}">p>{{template "cls" .Pwn}};
+>>>END;
+{{define "cls"}}{{.}}{{end}}`
+	tmpl, err := NewTemplate("page.jtl").Parse(page)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var out strings.Builder
+	err = tmpl.Execute(&out, map[string]any{
+		"Title": "a;b\nc",
+		"Class": `x" id="evil`,
+		"Kind":  "p>evil",
+		"Text":  evil,
+		"Items": []string{"> 5", evil},
+		"Raw":   JTL("kept > as it is"),
+		"Pwn":   `x" id="evil">button>pwned;`,
+	})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	parsed, err := jtl.Parse(out.String())
+	if err != nil {
+		t.Fatalf("Rendered page does not parse: %v\n%s", err, out.String())
+	}
+	if len(parsed) != 6 {
+		t.Fatalf("Expected 6 elements, got %d:\n%s", len(parsed), out.String())
+	}
+	for _, element := range parsed {
+		element := element.(map[string]any)
+		if element["id"] == "evil" || element["KEY"] == "evil" {
+			t.Errorf("Data injected an element: %v", element)
+		}
+	}
+
+	first := parsed[0].(map[string]any)
+	if first["class"] != "x' id='evil" || first["KEY"] != "p\u203aevil" {
+		t.Errorf("Unexpected attribute escaping: %v", first)
+	}
+	if content := first["Content"].(string); !strings.Contains(content, "\u203a>>END\u037e") || !strings.HasPrefix(content, "hi\u037e\n") {
+		t.Errorf("Unexpected content escaping: %q", content)
+	}
+	if item := parsed[2].(map[string]any); item["Content"] != "> 5" {
+		t.Errorf("A > in the middle of a line should stay, got %q", item["Content"])
+	}
+	if raw := parsed[4].(map[string]any); raw["Content"] != "kept > as it is" {
+		t.Errorf("JTL values should not be escaped, got %q", raw["Content"])
+	}
+
+	if included := parsed[5].(map[string]any); included["KEY"] != "p" || included["class"] != "x' id='evil'\u203abutton\u203apwned;" {
+		t.Errorf("An included template should be escaped where it's included: %v", included)
+	}
+
+	env, err := jtl.ParseEnv(out.String())
+	if err != nil || env["TITLE"] != "a\u037eb c" {
+		t.Errorf("Unexpected ENV value %q: %v", env["TITLE"], err)
+	}
+}
+
+func TestTemplateBranches(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		data any
+	}{
+		{"range", ">class=\"a\">p>{{range .}}{{.}};\n    {{end}}",
+			[]string{"ok", `>class="evil">button>Injected`}},
+		{"if without else", "{{if .A}}>class=\"a\">p>{{else}}>class=\"a\">p>x;\n    {{end}}{{.V}};",
+			map[string]any{"A": false, "V": `>class="evil">button>Injected`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := NewTemplate(tt.name).Parse(tt.page)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			var out strings.Builder
+			if err := tmpl.Execute(&out, tt.data); err == nil || !strings.Contains(err.Error(), "branches end in different contexts") {
+				t.Errorf("Expected the branches to be rejected, got %v:\n%s", err, out.String())
+			}
+			if err := tmpl.Execute(&out, tt.data); err == nil {
+				t.Error("Expected the template to keep failing")
+			}
+		})
+	}
+
+	// a list built up inside one element is fine
+	tmpl, err := NewTemplate("list").Parse(">>>DOCTYPE=JTL\n>>>BEGIN;\n    >id=\"list\">p>{{range .}}{{.}}, {{end}}{{if false}}none{{end}};\n>>>END;\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, []string{"a;", ">b"}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if parsed, err := jtl.Parse(out.String()); err != nil || len(parsed) != 1 {
+		t.Errorf("Unexpected page %v, %v:\n%s", parsed, err, out.String())
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"header.jtl": `    >id="title">p>{{.Title}};` + "\n",
+		"page.jtl":   ">>>DOCTYPE=JTL\n>>>BEGIN;\n{{template \"header.jtl\" .}}    >id=\"body\">p>{{.Body}};\n>>>END;\n",
+		"broken.jtl": "{{.Missing.Field}}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpl, err := ParseTemplateGlob(filepath.Join(dir, "*.jtl"))
+	if err != nil {
+		t.Fatalf("ParseTemplateGlob failed: %v", err)
+	}
+
+	mux := NewServeMux()
+	mux.HandleFunc("/page.jtl", func(w ResponseWriter, r *Request) {
+		tmpl.Render(w, "page.jtl", map[string]string{"Title": "scores;\n>id=\"x\">p>y;", "Body": "hello"})
+	})
+	mux.HandleFunc("/broken.jtl", func(w ResponseWriter, r *Request) {
+		if err := tmpl.Render(w, "broken.jtl", 42); err == nil {
+			t.Error("Expected an error rendering broken.jtl")
+		}
+	})
+	address := startServer(t, &Server{Handler: mux})
+
+	resp, err := (&Client{}).Fetch(context.Background(), address, "/page.jtl")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	parsed, err := jtl.Parse(string(resp.Body))
+	if err != nil || len(parsed) != 2 || resp.Type != "jtl" {
+		t.Fatalf("Unexpected page %v, %v:\n%s", parsed, err, resp.Body)
+	}
+	if got := status(t, address, "/broken.jtl"); got != 500 {
+		t.Errorf("Expected a 500 for a failing template, got %d", got)
+	}
+
+	if _, err := ParseTemplateGlob(filepath.Join(dir, "*.none")); err == nil {
+		t.Error("Expected an error for a pattern matching nothing")
+	}
+}
@@ -0,0 +1,101 @@
+package jtltp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSigned writes a certificate for localhost and its key to dir.
+func selfSigned(t *testing.T, dir string) (certFile string, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+
+	cert, _ := x509.ParseCertificate(der)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSigned(t, t.TempDir())
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	server := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) { w.SendGood("secret", "txt") })}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.ServeTLS(ctx, listener, certFile, keyFile) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	url := "jtltps://localhost:" + port + "/index.jtl"
+
+	trusting := &Client{TLSConfig: &tls.Config{RootCAs: pool}}
+	resp, err := trusting.Get(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Fetch over TLS failed: %v", err)
+	}
+	if string(resp.Body) != "secret" {
+		t.Errorf("Unexpected body: %q", resp.Body)
+	}
+
+	// the system roots don't know a self-signed certificate
+	if _, err := (&Client{}).Get(context.Background(), url); !errors.Is(err, ErrCertificate) {
+		t.Errorf("Expected ErrCertificate, got %v", err)
+	}
+
+	insecure := &Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	if _, err := insecure.Get(context.Background(), url); err != nil {
+		t.Errorf("Insecure client failed: %v", err)
+	}
+
+	// a plain client gets nothing sensible out of a TLS server
+	plain := &Client{ReadTimeout: 500 * time.Millisecond}
+	if _, err := plain.Get(context.Background(), "jtltp://localhost:"+port+"/index.jtl"); err == nil {
+		t.Error("Expected plain JTLTP against a TLS server to fail")
+	}
+}
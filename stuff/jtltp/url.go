@@ -0,0 +1,74 @@
+package jtltp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// URL schemes for JTLTP addresses, jtltp://host:port/path, and for JTLTP
+// over TLS, jtltps://host:port/path.
+const (
+	Scheme    = "jtltp"
+	SchemeTLS = "jtltps"
+)
+
+// Ports used when a URL leaves out the port.
+const (
+	DefaultPort    = "8080"
+	DefaultTLSPort = "8443"
+)
+
+// IsURL reports whether s looks like a JTLTP URL rather than a file path.
+func IsURL(s string) bool {
+	lower := strings.ToLower(s)
+	return strings.HasPrefix(lower, Scheme+"://") || strings.HasPrefix(lower, SchemeTLS+"://")
+}
+
+// IsTLS reports whether u asks for an encrypted connection.
+func IsTLS(u *url.URL) bool {
+	return u.Scheme == SchemeTLS
+}
+
+// ParseURL parses a jtltp:// or jtltps:// URL.
+func ParseURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != Scheme && u.Scheme != SchemeTLS {
+		return nil, fmt.Errorf("jtltp: unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("jtltp: missing host in " + rawurl)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+// HostPort returns the address to dial for u.
+func HostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if IsTLS(u) {
+		return net.JoinHostPort(u.Hostname(), DefaultTLSPort)
+	}
+	return net.JoinHostPort(u.Hostname(), DefaultPort)
+}
+
+// RequestPath returns what to put in JTLTP-GET for u.
+func RequestPath(u *url.URL) string {
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
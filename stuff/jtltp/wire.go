@@ -0,0 +1,335 @@
+package jtltp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is the framed protocol version spoken by this package. A peer that
+// doesn't open its message with JTLTP-VERSION=[...] is treated as version 1,
+// the original single-read format.
+const Version = 2
+
+// MaxBodySize caps the JTLTP-LENGTH a peer may announce.
+const MaxBodySize = 64 << 20
+
+const maxHeaderSize = 64 << 10
+
+const (
+	fieldVersion = "JTLTP-VERSION"
+	fieldLength  = "JTLTP-LENGTH"
+	fieldGet     = "JTLTP-GET"
+	fieldStatus  = "JTLTP-STATUS"
+	fieldType    = "JTLTP-TYPE"
+)
+
+var errMalformed = errors.New("jtltp: malformed message")
+
+// errVersion is a frame of a protocol version this package doesn't speak
+var errVersion = errors.New("jtltp: unsupported protocol version")
+
+// field is one NAME=[value] pair of a frame header
+type field struct {
+	name  string
+	value string
+}
+
+// frame is a version 2 message:
+//
+//	JTLTP-VERSION=[2] JTLTP-STATUS=[200] JTLTP-TYPE=[jtl] JTLTP-LENGTH=[27]\n
+//	<27 bytes of body>
+//
+// Values escape '\', ']' and newlines with a backslash so any string fits in
+// the header line. The body is raw bytes of exactly JTLTP-LENGTH.
+type frame struct {
+	fields []field
+	body   []byte
+	legacy bool // sent as a version 1 request instead, see writeLegacyRequest
+}
+
+func (f *frame) get(name string) (string, bool) {
+	for _, fl := range f.fields {
+		if fl.name == name {
+			return fl.value, true
+		}
+	}
+	return "", false
+}
+
+func (f *frame) set(name, value string) {
+	for i := range f.fields {
+		if f.fields[i].name == name {
+			f.fields[i].value = value
+			return
+		}
+	}
+	f.fields = append(f.fields, field{name, value})
+}
+
+func escapeValue(value string) string {
+	if !strings.ContainsAny(value, "\\]\n") {
+		return value
+	}
+	// byte by byte, ranging over runes would mangle values that aren't UTF-8
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case ']':
+			b.WriteString(`\]`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func validFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFrame writes the version marker, the fields of f and the body length,
+// followed by the body itself.
+func writeFrame(w io.Writer, f *frame) error {
+	var b strings.Builder
+	b.WriteString(fieldVersion + "=[" + strconv.Itoa(Version) + "]")
+	for _, fl := range f.fields {
+		if fl.name == fieldVersion || fl.name == fieldLength {
+			continue
+		}
+		if !validFieldName(fl.name) {
+			return fmt.Errorf("jtltp: invalid field name %q", fl.name)
+		}
+		b.WriteString(" " + fl.name + "=[" + escapeValue(fl.value) + "]")
+	}
+	b.WriteString(" " + fieldLength + "=[" + strconv.Itoa(len(f.body)) + "]\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	if len(f.body) > 0 {
+		if _, err := w.Write(f.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHeader splits a header line (without its newline) into fields.
+func parseHeader(line string) ([]field, error) {
+	var fields []field
+	i := 0
+	for i < len(line) {
+		eq := strings.Index(line[i:], "=[")
+		if eq <= 0 {
+			return nil, errMalformed
+		}
+		name := line[i : i+eq]
+		if !validFieldName(name) {
+			return nil, errMalformed
+		}
+		i += eq + 2
+
+		var value strings.Builder
+		closed := false
+		for i < len(line) {
+			c := line[i]
+			i++
+			if c == ']' {
+				closed = true
+				break
+			}
+			if c != '\\' {
+				value.WriteByte(c)
+				continue
+			}
+			if i >= len(line) {
+				return nil, errMalformed
+			}
+			switch line[i] {
+			case '\\', ']':
+				value.WriteByte(line[i])
+			case 'n':
+				value.WriteByte('\n')
+			default:
+				return nil, errMalformed
+			}
+			i++
+		}
+		if !closed {
+			return nil, errMalformed
+		}
+		fields = append(fields, field{name, value.String()})
+
+		if i < len(line) {
+			if line[i] != ' ' {
+				return nil, errMalformed
+			}
+			i++
+		}
+	}
+	return fields, nil
+}
+
+// readFrame reads one version 2 frame. The caller has already checked that
+// the stream starts with the version marker.
+func readFrame(r *bufio.Reader) (*frame, error) {
+	// not ReadLine, it hands back a line cut short by a timeout or a hang up
+	// as if it were whole
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxHeaderSize+1 {
+			return nil, fmt.Errorf("%w: header too large", errMalformed)
+		}
+		if err == nil {
+			break
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+	fields, err := parseHeader(string(line))
+	if err != nil {
+		return nil, err
+	}
+	f := &frame{fields: fields}
+
+	version, ok := f.get(fieldVersion)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", errMalformed, fieldVersion)
+	}
+	if v, err := strconv.Atoi(version); err != nil || v != Version {
+		return nil, fmt.Errorf("%w %q", errVersion, version)
+	}
+
+	lengthStr, ok := f.get(fieldLength)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", errMalformed, fieldLength)
+	}
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("%w: bad %s %q", errMalformed, fieldLength, lengthStr)
+	}
+	if length > MaxBodySize {
+		return nil, fmt.Errorf("%w: body of %d bytes exceeds limit", errMalformed, length)
+	}
+
+	f.body = make([]byte, length)
+	if _, err := io.ReadFull(r, f.body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// isFramed reports whether the next bytes on r open a version 2 frame. It
+// peeks one byte at a time so a short legacy message isn't left waiting for
+// bytes that will never come.
+func isFramed(r *bufio.Reader) (bool, error) {
+	marker := fieldVersion + "=["
+	for n := 1; n <= len(marker); n++ {
+		peek, err := r.Peek(n)
+		if err != nil {
+			if len(peek) > 0 && err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		if peek[n-1] != marker[n-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// legacy (version 1) messages
+
+var legacyResponse = regexp.MustCompile(`(?s)^JTLTP-STATUS=\[([0-9]+)\]\s+JTLTP-TYPE=\[([a-zA-Z0-9_-]+)\]\s+JTLTP=MSG=\[(.*)\]\s*$`)
+
+// readLegacyResponse reads a version 1 response. Those servers close the
+// connection after writing, so everything up to EOF belongs to the message.
+func readLegacyResponse(r *bufio.Reader) (*frame, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, MaxBodySize+maxHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	match := legacyResponse.FindSubmatch(raw)
+	if match == nil {
+		return nil, errMalformed
+	}
+	return &frame{
+		fields: []field{{fieldStatus, string(match[1])}, {fieldType, string(match[2])}},
+		body:   match[3],
+	}, nil
+}
+
+// readLegacyRequest reads a version 1 request, JTLTP-GET=[what]. Old clients
+// never close their side, so stop at the closing bracket.
+func readLegacyRequest(r *bufio.Reader) (*frame, error) {
+	prefix := fieldGet + "=["
+	var buf []byte
+	for {
+		chunk, err := r.ReadSlice(']')
+		buf = append(buf, chunk...)
+		if len(buf) > maxHeaderSize {
+			return nil, fmt.Errorf("%w: request too large", errMalformed)
+		}
+		if err == nil {
+			break
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return nil, err
+	}
+	raw := string(buf)
+	start := strings.Index(raw, prefix)
+	if start == -1 {
+		return nil, errMalformed
+	}
+	what := raw[start+len(prefix) : len(raw)-1]
+	if what == "" {
+		return nil, errMalformed
+	}
+	return &frame{fields: []field{{fieldGet, what}}}, nil
+}
+
+// writeLegacyRequest writes the JTLTP-GET=[what] of f the way version 1
+// clients did, for servers that know nothing else
+func writeLegacyRequest(w io.Writer, f *frame) error {
+	what, _ := f.get(fieldGet)
+	_, err := io.WriteString(w, fieldGet+"=["+what+"]")
+	return err
+}
+
+func writeLegacyResponse(w io.Writer, status string, doctype string, body []byte) error {
+	_, err := io.WriteString(w, "JTLTP-STATUS=["+status+"] JTLTP-TYPE=["+doctype+"] JTLTP=MSG=["+string(body)+"]")
+	return err
+}
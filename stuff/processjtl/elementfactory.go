@@ -13,11 +13,21 @@ var elementCreators = map[string]ElementCreator{
 	"div":       createDiv, // Add div creator
 }
 
+// Styles a container passes down to its children. The others, like sizes,
+// margins and colors, belong to the container's own box.
+var inheritedStyles = map[string]bool{
+	"font-family": true,
+	"text-align":  true,
+	"line-height": true,
+}
+
 func RegisterElement(elementType string, creator ElementCreator) {
 	elementCreators[elementType] = creator
 }
 
-func CreateElement(elementType string, content string, x, y, width, height int32, styles map[string]string, baseFontSize int32) UIElement {
+// CreateElement builds an element of a registered type with children, the
+// elements of its nested JTL components, inside it
+func CreateElement(elementType string, content string, x, y, width, height int32, styles map[string]string, baseFontSize int32, children ...UIElement) UIElement {
 	creator, exists := elementCreators[elementType]
 	if !exists {
 		return nil
@@ -27,16 +37,26 @@ func CreateElement(elementType string, content string, x, y, width, height int32
 
 	// If children exist in the interface (from JTL parser)
 	if baseEl, ok := element.(interface{ GetBaseElement() *BaseElement }); ok {
-		// Apply parent's styles to all children (with lower priority)
+		base := baseEl.GetBaseElement()
+		for _, child := range children {
+			base.AddChild(child)
+		}
+
+		// Keep the styles for the layout, which reads sizes and margins from them
+		if base.Styles == nil {
+			base.Styles = make(map[string]string)
+		}
 		for key, value := range styles {
 			if key != "class" && key != "id" {
-				for _, child := range baseEl.GetBaseElement().Children {
-					// Only apply style if child doesn't already have it
-					if childBase, ok := child.(interface{ GetBaseElement() *BaseElement }); ok {
-						if _, exists := childBase.GetBaseElement().Styles[key]; !exists {
-							child.AddStyle(key + ":" + value)
-						}
-					}
+				base.Styles[key] = value
+			}
+		}
+
+		// Apply parent's styles to all children (with lower priority)
+		for key, value := range styles {
+			if inheritedStyles[key] {
+				for _, child := range base.Children {
+					inheritStyle(child, key, value)
 				}
 			}
 		}
@@ -45,6 +65,23 @@ func CreateElement(elementType string, content string, x, y, width, height int32
 	return element
 }
 
+// inheritStyle gives element, and the children that get their styles from it,
+// a style of its parent, unless element has one of its own
+func inheritStyle(element UIElement, key, value string) {
+	baseEl, ok := element.(interface{ GetBaseElement() *BaseElement })
+	if !ok {
+		return
+	}
+	base := baseEl.GetBaseElement()
+	if _, exists := base.Styles[key]; exists {
+		return
+	}
+	element.AddStyle(key + ":" + value)
+	for _, child := range base.Children {
+		inheritStyle(child, key, value)
+	}
+}
+
 func createButton(content string, x, y, width, height int32, styles map[string]string, baseFontSize int32) UIElement {
 	button := NewButton(content, x, y, width, height, 20,
 		sdl.Color{R: 200, G: 200, B: 200, A: 255},
@@ -75,8 +112,18 @@ func createTextField(content string, x, y, width, height int32, styles map[strin
 		sdl.Color{R: 100, G: 100, B: 100, A: 255})
 	textField.Text = content
 
+	// Set class and id first
+	if class, ok := styles["class"]; ok {
+		textField.Class = class
+	}
+	if id, ok := styles["id"]; ok {
+		textField.ID = id
+	}
+
 	for key, value := range styles {
-		TranslateStyle(key+":"+value, textField)
+		if key != "class" && key != "id" {
+			TranslateStyle(key+":"+value, textField)
+		}
 	}
 	return textField
 }
@@ -86,8 +133,18 @@ func createText(content string, x, y, width, height int32, styles map[string]str
 	text := NewText(content, x, y, baseFontSize,
 		sdl.Color{R: 0, G: 0, B: 0, A: 255})
 
+	// Set class and id first
+	if class, ok := styles["class"]; ok {
+		text.Class = class
+	}
+	if id, ok := styles["id"]; ok {
+		text.ID = id
+	}
+
 	for key, value := range styles {
-		TranslateStyle(key+":"+value, text)
+		if key != "class" && key != "id" {
+			TranslateStyle(key+":"+value, text)
+		}
 	}
 	return text
 }
@@ -0,0 +1,145 @@
+package processjtl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"jtlweb/stuff/jtltp"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// pendingFetch is a document.fetch running on its own goroutine
+type pendingFetch struct {
+	id       int
+	callback lua.LValue // a lua function or a string of lua to run
+	cancel   context.CancelFunc
+	resp     *jtltp.Response
+	err      error
+}
+
+var fetchMutex sync.Mutex
+var fetchID int
+var fetchesInFlight = map[int]*pendingFetch{}
+var fetchesDone []*pendingFetch // finished, waiting for RunFetchCallbacks
+
+// startFetch runs request in the background, target is either a full url or
+// a path on Site. the callback runs on the main loop once it's done.
+func startFetch(target string, request *jtltp.Request, callback lua.LValue) int {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	fetchMutex.Lock()
+	fetchID++
+	fetch := &pendingFetch{id: fetchID, callback: callback, cancel: cancel}
+	fetchesInFlight[fetch.id] = fetch
+	fetchMutex.Unlock()
+
+	// Site changes when the browser navigates, so it's read here on the main
+	// loop rather than by the goroutine
+	go func(site string) {
+		defer cancel()
+		var resp *jtltp.Response
+		var err error
+		if jtltp.IsURL(target) {
+			resp, err = FetchClient.DoURL(ctx, target, request)
+		} else {
+			resp, err = FetchClient.Do(ctx, site, request)
+		}
+
+		fetchMutex.Lock()
+		defer fetchMutex.Unlock()
+		// cancelled fetches never reach their callback
+		if _, ok := fetchesInFlight[fetch.id]; !ok {
+			return
+		}
+		delete(fetchesInFlight, fetch.id)
+		fetch.resp, fetch.err = resp, err
+		fetchesDone = append(fetchesDone, fetch)
+	}(Site)
+
+	return fetch.id
+}
+
+// cancelFetch stops a fetch, reporting whether it was still running
+func cancelFetch(id int) bool {
+	fetchMutex.Lock()
+	defer fetchMutex.Unlock()
+	fetch, ok := fetchesInFlight[id]
+	if ok {
+		delete(fetchesInFlight, id)
+		fetch.cancel()
+	}
+	return ok
+}
+
+// CancelFetches stops every fetch and subscription of the open page and drops
+// the answers that haven't been handed to their callbacks yet. Call it when
+// leaving the page.
+func CancelFetches() {
+	fetchMutex.Lock()
+	for id, fetch := range fetchesInFlight {
+		fetch.cancel()
+		delete(fetchesInFlight, id)
+	}
+	fetchesDone = nil
+	fetchMutex.Unlock()
+
+	cancelSubscriptions()
+}
+
+// RunFetchCallbacks hands finished fetches and subscription messages to their
+// lua callbacks. Lua isn't safe to use from several goroutines, so the main
+// loop calls this every frame.
+func RunFetchCallbacks() {
+	fetchMutex.Lock()
+	done := fetchesDone
+	fetchesDone = nil
+	fetchMutex.Unlock()
+
+	for _, fetch := range done {
+		runFetchCallback(fetch)
+	}
+	runSubscriptionEvents()
+}
+
+// runFetchCallback calls callback(response, err). string callbacks, like
+// [[onResponse()]], find them in the globals response and fetchError instead
+func runFetchCallback(fetch *pendingFetch) {
+	if luaState == nil {
+		return
+	}
+	L := luaState
+
+	// non-200 answers still go to the script, it can check the status itself
+	var respValue lua.LValue = lua.LNil
+	var errValue lua.LValue = lua.LNil
+	var statusErr *jtltp.StatusError
+	if fetch.err == nil || errors.As(fetch.err, &statusErr) {
+		respValue = responseToLuaTable(L, fetch.resp)
+	} else {
+		fmt.Printf("Error fetching: %v\n", fetch.err)
+		errValue = lua.LString(fetch.err.Error())
+	}
+
+	var err error
+	switch callback := fetch.callback.(type) {
+	case *lua.LFunction:
+		err = L.CallByParam(lua.P{Fn: callback, NRet: 0, Protect: true}, respValue, errValue)
+	case lua.LString:
+		L.SetGlobal("response", respValue)
+		L.SetGlobal("fetchError", errValue)
+		err = L.DoString(string(callback))
+	}
+	if err != nil {
+		fmt.Printf("Error executing fetch callback: %v\n", err)
+	}
+}
+
+// luaCancelFetch is document.cancelFetch(id), it returns true if the fetch
+// was still running
+func luaCancelFetch(L *lua.LState) int {
+	L.Push(lua.LBool(cancelFetch(L.ToInt(1))))
+	return 1
+}
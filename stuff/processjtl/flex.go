@@ -0,0 +1,218 @@
+package processjtl
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A div with display: flex lines its children up in a row, or a column with
+// flex-direction: column, instead of the block flow. Along that line
+// justify-content spreads out the space left over and flex-grow hands it to
+// the children that ask for it, across it align-items lines them up.
+
+// flexItem is a child of a flex container with its margins
+type flexItem struct {
+	element UIElement
+	margins margins
+	grow    float64
+}
+
+func flexItems(children []UIElement, width int32) []flexItem {
+	items := make([]flexItem, 0, len(children))
+	for _, child := range children {
+		grow, _ := strconv.ParseFloat(strings.TrimSpace(elementStyles(child)["flex-grow"]), 64)
+		items = append(items, flexItem{child, elementMargins(child, width), max(grow, 0)})
+	}
+	return items
+}
+
+func isRow(styles map[string]string) bool {
+	direction := strings.TrimSpace(styles["flex-direction"])
+	return direction == "" || direction == "row"
+}
+
+// containerGap is the space between two children of a flex or grid container,
+// a percentage is of the main size
+func containerGap(styles map[string]string, mainSize int32) int32 {
+	gap, _ := parseLength(styles["gap"], mainSize)
+	return max(gap, 0)
+}
+
+// flexGap is containerGap for a flex container width wide. In a column a
+// percentage is of the height style, the div's own height depends on the gap.
+func flexGap(div *Div, width, windowHeight int32) int32 {
+	if isRow(div.Styles) {
+		return containerGap(div.Styles, width)
+	}
+	height, _ := parseLength(div.Styles["height"], windowHeight)
+	return containerGap(div.Styles, max(height-2*divPadding, 0))
+}
+
+// measureFlex sizes the children of a flex container width wide inside its
+// padding and returns the height of its content. Space is only handed out
+// along a row here, a column's free height isn't known until the div's own
+// height is.
+func measureFlex(div *Div, width, windowHeight int32) int32 {
+	styles := div.Styles
+	items := flexItems(div.Children, width)
+	stretch := alignItems(styles) == "stretch"
+
+	if !isRow(styles) {
+		gap := flexGap(div, width, windowHeight)
+		var height int32
+		for i, item := range items {
+			inner := max(width-item.margins.left-item.margins.right, 0)
+			_, hasWidth := parseLength(elementStyles(item.element)["width"], inner)
+			var h int32
+			if stretch && !hasWidth {
+				_, h = measureAt(item.element, inner, windowHeight)
+			} else {
+				_, h = measure(item.element, inner, windowHeight)
+			}
+			if i > 0 {
+				height += gap
+			}
+			height += item.margins.top + h + item.margins.bottom
+		}
+		return height
+	}
+
+	gap := flexGap(div, width, windowHeight)
+	used := gap * int32(max(len(items)-1, 0))
+	var totalGrow float64
+	for _, item := range items {
+		w, _ := measure(item.element, max(width-item.margins.left-item.margins.right, 0), windowHeight)
+		used += item.margins.left + w + item.margins.right
+		totalGrow += item.grow
+	}
+
+	// the children that grow share what's left of the row by their flex-grow
+	if free := width - used; free > 0 && totalGrow > 0 {
+		for _, item := range items {
+			if item.grow > 0 {
+				w, _ := item.element.GetSize()
+				measureAt(item.element, w+int32(float64(free)*item.grow/totalGrow), windowHeight)
+			}
+		}
+	}
+
+	var height int32
+	for _, item := range items {
+		_, h := item.element.GetSize()
+		height = max(height, item.margins.top+h+item.margins.bottom)
+	}
+	return height
+}
+
+// placeFlex positions the measured children of a flex container inside the
+// box at x, y
+func placeFlex(div *Div, x, y, width, height, windowHeight int32) {
+	styles := div.Styles
+	items := flexItems(div.Children, width)
+	align := alignItems(styles)
+	row := isRow(styles)
+
+	mainSize := width
+	if !row {
+		mainSize = height
+	}
+	gap := flexGap(div, width, windowHeight)
+
+	used := gap * int32(max(len(items)-1, 0))
+	var totalGrow float64
+	for _, item := range items {
+		used += mainLength(item, row)
+		totalGrow += item.grow
+	}
+	free := mainSize - used
+
+	// a column can only grow its children once the div's height is known
+	if !row && free > 0 && totalGrow > 0 {
+		for _, item := range items {
+			if item.grow > 0 {
+				w, h := item.element.GetSize()
+				item.element.SetSize(w, h+int32(float64(free)*item.grow/totalGrow))
+			}
+		}
+		free = 0
+	}
+
+	offset, between := justifyContent(styles, free, len(items))
+	for i, item := range items {
+		if i > 0 {
+			offset += gap + between
+		}
+		w, h := item.element.GetSize()
+		m := item.margins
+		if row {
+			crossSpace := height - m.top - m.bottom
+			if _, hasHeight := parseLength(elementStyles(item.element)["height"], windowHeight); align == "stretch" && !hasHeight {
+				h = max(crossSpace, 0)
+				item.element.SetSize(w, h)
+			}
+			place(item.element, x+offset+m.left, y+m.top+alignOffset(align, crossSpace-h), windowHeight)
+		} else {
+			crossSpace := width - m.left - m.right
+			place(item.element, x+m.left+alignOffset(align, crossSpace-w), y+offset+m.top, windowHeight)
+		}
+		offset += mainLength(item, row)
+	}
+}
+
+// mainLength is the room a measured child takes along the line
+func mainLength(item flexItem, row bool) int32 {
+	w, h := item.element.GetSize()
+	if row {
+		return item.margins.left + w + item.margins.right
+	}
+	return item.margins.top + h + item.margins.bottom
+}
+
+func alignItems(styles map[string]string) string {
+	switch align := strings.TrimSpace(styles["align-items"]); align {
+	case "flex-start", "start":
+		return "flex-start"
+	case "flex-end", "end":
+		return "flex-end"
+	case "center":
+		return "center"
+	}
+	return "stretch"
+}
+
+// alignOffset places a child in the free space across the line
+func alignOffset(align string, free int32) int32 {
+	free = max(free, 0)
+	switch align {
+	case "center":
+		return free / 2
+	case "flex-end":
+		return free
+	}
+	return 0
+}
+
+// justifyContent returns where the first of count children starts and the
+// extra space put between them, for free space left along the line
+func justifyContent(styles map[string]string, free int32, count int) (int32, int32) {
+	if free <= 0 || count == 0 {
+		return 0, 0
+	}
+	n := int32(count)
+	switch strings.TrimSpace(styles["justify-content"]) {
+	case "flex-end", "end":
+		return free, 0
+	case "center":
+		return free / 2, 0
+	case "space-between":
+		if n == 1 {
+			return 0, 0
+		}
+		return 0, free / (n - 1)
+	case "space-around":
+		return free / n / 2, free / n
+	case "space-evenly":
+		return free / (n + 1), free / (n + 1)
+	}
+	return 0, 0
+}
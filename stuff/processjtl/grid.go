@@ -0,0 +1,110 @@
+package processjtl
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A div with display: grid puts its children in columns, left to right and
+// then on to the next row. grid-template-columns sizes the columns in px, %
+// of the div's width or fr, a share of what the others leave. Every row is as
+// tall as its tallest child, and each child fills its cell unless it has a
+// width or height of its own.
+
+// gridColumns works out the width of every column of a grid width wide
+func gridColumns(styles map[string]string, width int32) []int32 {
+	tracks := strings.Fields(styles["grid-template-columns"])
+	if len(tracks) == 0 {
+		tracks = []string{"1fr"}
+	}
+	gap := containerGap(styles, width)
+
+	columns := make([]int32, len(tracks))
+	fractions := make([]float64, len(tracks))
+	free := width - gap*int32(len(tracks)-1)
+	var totalFractions float64
+	for i, track := range tracks {
+		if fr, ok := strings.CutSuffix(track, "fr"); ok {
+			n, err := strconv.ParseFloat(fr, 64)
+			if err != nil || n < 0 {
+				n = 1
+			}
+			fractions[i] = n
+			totalFractions += n
+			continue
+		}
+		if length, ok := parseLength(track, width); ok {
+			columns[i] = max(length, 0)
+			free -= columns[i]
+			continue
+		}
+		// anything else shares the rest like 1fr
+		fractions[i] = 1
+		totalFractions += 1
+	}
+
+	if free > 0 && totalFractions > 0 {
+		for i := range columns {
+			columns[i] += int32(float64(free) * fractions[i] / totalFractions)
+		}
+	}
+	return columns
+}
+
+// measureGrid sizes the children of a grid container width wide inside its
+// padding and returns the height of its content
+func measureGrid(div *Div, width, windowHeight int32) int32 {
+	columns := gridColumns(div.Styles, width)
+	for i, item := range flexItems(div.Children, width) {
+		cell := max(columns[i%len(columns)]-item.margins.left-item.margins.right, 0)
+		if _, hasWidth := parseLength(elementStyles(item.element)["width"], cell); hasWidth {
+			measure(item.element, cell, windowHeight)
+		} else {
+			measureAt(item.element, cell, windowHeight)
+		}
+	}
+
+	rows := gridRows(div.Children, len(columns), width)
+	height := containerGap(div.Styles, width) * int32(max(len(rows)-1, 0))
+	for _, row := range rows {
+		height += row
+	}
+	return height
+}
+
+// gridRows returns the height of every row, that of its tallest child
+func gridRows(children []UIElement, columns int, width int32) []int32 {
+	rows := make([]int32, (len(children)+columns-1)/columns)
+	for i, item := range flexItems(children, width) {
+		_, h := item.element.GetSize()
+		rows[i/columns] = max(rows[i/columns], item.margins.top+h+item.margins.bottom)
+	}
+	return rows
+}
+
+// placeGrid positions the measured children of a grid container inside the
+// box at x, y
+func placeGrid(div *Div, x, y, width, windowHeight int32) {
+	columns := gridColumns(div.Styles, width)
+	rows := gridRows(div.Children, len(columns), width)
+	gap := containerGap(div.Styles, width)
+
+	cellY := y
+	for i, item := range flexItems(div.Children, width) {
+		column, row := i%len(columns), i/len(columns)
+		if column == 0 && row > 0 {
+			cellY += rows[row-1] + gap
+		}
+		cellX := x
+		for _, w := range columns[:column] {
+			cellX += w + gap
+		}
+
+		m := item.margins
+		if _, hasHeight := parseLength(elementStyles(item.element)["height"], windowHeight); !hasHeight {
+			w, _ := item.element.GetSize()
+			item.element.SetSize(w, max(rows[row]-m.top-m.bottom, 0))
+		}
+		place(item.element, cellX+m.left, cellY+m.top, windowHeight)
+	}
+}
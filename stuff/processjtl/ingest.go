@@ -0,0 +1,142 @@
+package processjtl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OrtheSnowJames/jtl"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// PageLoader builds a page from a document that arrives in pieces. Elements
+// are inserted as soon as they are complete, the scripts run once the whole
+// document is there.
+type PageLoader struct {
+	text     strings.Builder
+	inserted int // top level elements already in the document store
+}
+
+// BeginWebview clears the open page and returns a loader for the next one
+func BeginWebview() *PageLoader {
+	// answers meant for the previous page's scripts are dropped
+	CancelFetches()
+	luaState = lua.NewState()
+	clearDocuments()
+	return &PageLoader{}
+}
+
+// Feed adds the next piece of the document and inserts the elements that
+// can't change anymore
+func (p *PageLoader) Feed(chunk []byte) {
+	p.text.Write(chunk)
+
+	// only whole lines, a line cut in half could still parse as something else
+	text := p.text.String()
+	end := strings.LastIndex(text, "\n")
+	if end < 0 {
+		return
+	}
+	parsed, err := jtl.Parse(text[:end])
+	if err != nil {
+		// probably in the middle of an element, try again with more
+		return
+	}
+
+	// the last element may still get more content or children
+	p.insert(parsed, len(parsed)-1)
+}
+
+// Finish parses the whole document, inserts the rest of it and runs the
+// page's scripts
+func (p *PageLoader) Finish() (*Locker, []CanvasObject) {
+	// Parse JTL document
+	parsedDoc, err := jtl.Parse(p.text.String())
+	if err != nil {
+		fmt.Printf("Failed to parse JTL: %v\n", err)
+		return nil, nil
+	}
+
+	fmt.Printf("Parsed %d JTL components\n", len(parsedDoc))
+
+	// Store in memory
+	p.insert(parsedDoc, len(parsedDoc))
+
+	// Create objects from all documents
+	allDocs := getAllDocuments()
+	if len(allDocs) == 0 {
+		fmt.Println("No documents retrieved from database")
+		return nil, nil
+	}
+
+	objects = ToRaylib(allDocs)
+	if len(objects) == 0 {
+		fmt.Println("No objects created from documents")
+		return nil, nil
+	}
+
+	fmt.Printf("Created %d objects\n", len(objects))
+
+	// Extract and run scripts after objects are created
+	combinedScript := extractScripts(parsedDoc)
+
+	// Setup initial Lua environment
+	docTable := setupLuaEnvironment(luaState)
+	luaState.SetGlobal("document", docTable)
+	// Execute script after objects are created and stored
+	if err := luaState.DoString(combinedScript); err != nil {
+		fmt.Printf("Initial script execution error: %v\n", err)
+	}
+
+	return newLocker(objects), objects
+}
+
+// insert adds parsed[p.inserted:upTo] to the document store
+func (p *PageLoader) insert(parsed []interface{}, upTo int) {
+	for ; p.inserted < upTo; p.inserted++ {
+		if elemMap, ok := parsed[p.inserted].(map[string]interface{}); ok {
+			insertDocument(nestChildren(elemMap))
+		}
+	}
+}
+
+// nestChildren moves a div's leading children into its children list. A div
+// line should end in ; like >div>; but without it the parser keeps everything
+// up to the first line that does as the div's content, so the first child
+// ends up there as text.
+func nestChildren(comp map[string]interface{}) map[string]interface{} {
+	children, _ := comp["children"].([]interface{})
+	for i, child := range children {
+		if childMap, ok := child.(map[string]interface{}); ok {
+			children[i] = nestChildren(childMap)
+		}
+	}
+	if key, _ := comp["KEY"].(string); key != "div" {
+		return comp
+	}
+
+	content, _ := comp["Contents"].(string)
+	if strings.TrimSpace(content) == "" {
+		return comp
+	}
+	var doc strings.Builder
+	doc.WriteString(">>>DOCTYPE=JTL\n>>>BEGIN;\n")
+	for _, line := range strings.Split(content+";", "\n") {
+		doc.WriteString("    " + line + "\n")
+	}
+	doc.WriteString(">>>END;\n")
+	leading, err := jtl.Parse(doc.String())
+	if err != nil || len(leading) == 0 {
+		// plain text in a div, nothing to nest
+		return comp
+	}
+
+	for i, child := range leading {
+		if childMap, ok := child.(map[string]interface{}); ok {
+			leading[i] = nestChildren(childMap)
+		}
+	}
+	comp["children"] = append(leading, children...)
+	comp["Content"] = ""
+	comp["Contents"] = ""
+	return comp
+}
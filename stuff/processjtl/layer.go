@@ -39,6 +39,8 @@ func TranslateStyle(style string, element interface{}) {
 				e.Width = int32(width)
 			case *TextField:
 				e.Width = int32(width)
+			case *Div:
+				e.Width = int32(width)
 			case *BaseElement:
 				e.Width = int32(width)
 			}
@@ -59,6 +61,8 @@ func TranslateStyle(style string, element interface{}) {
 				e.Height = int32(height)
 			case *TextField:
 				e.Height = int32(height)
+			case *Div:
+				e.Height = int32(height)
 			case *BaseElement:
 				e.Height = int32(height)
 			}
@@ -77,6 +81,8 @@ func TranslateStyle(style string, element interface{}) {
 					e.Color = color
 				case *TextField:
 					e.Color = color
+				case *Div:
+					e.Color = color
 				case *BaseElement:
 					e.Color = color
 				}
@@ -96,6 +102,8 @@ func TranslateStyle(style string, element interface{}) {
 					e.BorderColor = color
 				case *TextField:
 					e.BorderColor = color
+				case *Div:
+					e.BorderColor = color
 				case *BaseElement:
 					e.BorderColor = color
 				}
@@ -124,29 +132,6 @@ func TranslateStyle(style string, element interface{}) {
 				button.Margin = int32(margin)
 			}
 
-		case "margin-left", "margin-right", "margin-up", "margin-down":
-			var margin int
-			if strings.HasSuffix(value, "%") {
-				percentage, _ := strconv.Atoi(strings.TrimSuffix(value, "%"))
-				windowWidth, _ := Window.GetSize()
-				margin = int(float64(windowWidth) * float64(percentage) / 100.0)
-			} else {
-				margin, _ = strconv.Atoi(value)
-			}
-
-			if text, ok := element.(*Text); ok {
-				switch key {
-				case "margin-left":
-					text.X = int32(margin) // Set absolute position instead of adding
-				case "margin-right":
-					text.X -= int32(margin)
-				case "margin-up":
-					text.Y += int32(margin)
-				case "margin-down":
-					text.Y -= int32(margin)
-				}
-			}
-
 		case "center":
 			if text, ok := element.(*Text); ok {
 				text.Center = value == "true"
@@ -186,8 +171,18 @@ var objects []CanvasObject
 // Says to raylib, but really i was too lazy to rename it to ToSDL2.
 func ToRaylib(jtlcomps []interface{}) []CanvasObject {
 	result := make([]CanvasObject, 0)
-	yOffset := int32(20)
-	margin := int32(20) // Fixed margin instead of screen-relative
+	for _, element := range buildElements(jtlcomps) {
+		result = append(result, element)
+	}
+
+	layoutPage(result)
+	return result
+}
+
+// buildElements creates the elements for parsed JTL components, along with
+// the elements of their children
+func buildElements(jtlcomps []interface{}) []UIElement {
+	result := make([]UIElement, 0)
 
 	for _, elem := range jtlcomps {
 		comp, ok := elem.(map[string]interface{})
@@ -216,21 +211,20 @@ func ToRaylib(jtlcomps []interface{}) []CanvasObject {
 			parsedStyles["id"] = id
 		}
 
-		// Use fixed dimensions instead of screen-relative
-		width := int32(200) // Fixed width
-		height := int32(40) // Fixed height
+		children, _ := comp["children"].([]interface{})
 
+		// Position and size are only a starting point, the layout sets both
 		if element := CreateElement(key, content,
-			margin, yOffset,
-			width, height, parsedStyles, 14); element != nil { // Use fixed font size
+			0, 0,
+			defaultWidth, defaultHeight, parsedStyles, 14, // Use fixed font size
+			buildElements(children)...); element != nil {
 
 			// Debug print
 			if baseEl, ok := element.(interface{ GetBaseElement() *BaseElement }); ok {
 				fmt.Printf("Created element with class: %s\n", baseEl.GetBaseElement().Class)
 			}
 
-			result = append(result, element.(CanvasObject))
-			yOffset += height + 20 // Fixed spacing
+			result = append(result, element)
 		}
 	}
 
@@ -0,0 +1,212 @@
+package processjtl
+
+import (
+	"jtlweb/stuff/shared"
+	"strconv"
+	"strings"
+)
+
+// The page is laid out in two passes. measure works out how big every element
+// wants to be, text by asking its font, then place stacks them top to bottom
+// in a block flow, each element on its own line. Both run again whenever the
+// document changes or the window is resized.
+
+const (
+	pagePadding    = 20 // space between the window edge and the page
+	blockSpacing   = 20 // space between two elements of a flow
+	buttonPaddingX = 20 // space between a button's text and its sides
+	buttonPaddingY = 10 // space between a button's text and its top and bottom
+	divPadding     = 10 // space between a div's border and its children
+
+	// size of the elements that have nothing to measure, like textfields
+	defaultWidth  = 200
+	defaultHeight = 40
+
+	// shown in place of a p without text
+	blankTextContent = "Blank String..."
+)
+
+// margins is the space kept free around an element
+type margins struct {
+	top, right, bottom, left int32
+}
+
+// layoutPage lays objects out inside the window and records how tall the page
+// came out for scrolling
+func layoutPage(objects []CanvasObject) {
+	windowWidth, windowHeight := Window.GetSize()
+	elements := make([]UIElement, 0, len(objects))
+	for _, obj := range objects {
+		if element, ok := obj.(UIElement); ok {
+			elements = append(elements, element)
+		}
+	}
+
+	width := max(windowWidth-2*pagePadding, 0)
+	height := measureFlow(elements, width, windowHeight)
+	placeFlow(elements, pagePadding, pagePadding, width, windowHeight)
+	shared.ContentHeight = int(height + 2*pagePadding)
+}
+
+// Relayout lays the open page out again, after the window changed size or a
+// script changed an element's style
+func Relayout() {
+	ObjectsMutex.Lock()
+	defer ObjectsMutex.Unlock()
+	layoutPage(objects)
+}
+
+// measureFlow sizes elements for a flow width wide and returns the height the
+// flow takes
+func measureFlow(elements []UIElement, width, windowHeight int32) int32 {
+	var height int32
+	for i, element := range elements {
+		m := elementMargins(element, width)
+		_, h := measure(element, max(width-m.left-m.right, 0), windowHeight)
+		if i > 0 {
+			height += blockSpacing
+		}
+		height += m.top + h + m.bottom
+	}
+	return height
+}
+
+// placeFlow positions already measured elements one under the other from x, y
+func placeFlow(elements []UIElement, x, y, width, windowHeight int32) {
+	for i, element := range elements {
+		m := elementMargins(element, width)
+		if i > 0 {
+			y += blockSpacing
+		}
+		place(element, x+m.left, y+m.top, windowHeight)
+		_, h := element.GetSize()
+		y += m.top + h + m.bottom
+	}
+}
+
+// measure sets the size element wants with width to spare and returns it. A
+// width or height style wins over what the content needs.
+func measure(element UIElement, width, windowHeight int32) (int32, int32) {
+	if fixedWidth, ok := parseLength(elementStyles(element)["width"], width); ok {
+		return measureAt(element, fixedWidth, windowHeight)
+	}
+	w, h := intrinsicSize(element, width, windowHeight)
+	if value, ok := parseLength(elementStyles(element)["height"], windowHeight); ok {
+		h = value
+	}
+	element.SetSize(w, h)
+	return w, h
+}
+
+// measureAt is measure for an element that must be width wide, its children
+// are measured against that width
+func measureAt(element UIElement, width, windowHeight int32) (int32, int32) {
+	_, h := intrinsicSize(element, width, windowHeight)
+	if value, ok := parseLength(elementStyles(element)["height"], windowHeight); ok {
+		h = value
+	}
+	element.SetSize(width, h)
+	return width, h
+}
+
+// intrinsicSize is the size element needs for its content
+func intrinsicSize(element UIElement, width, windowHeight int32) (int32, int32) {
+	switch e := element.(type) {
+	case *Text:
+		return e.wrap(width)
+	case *Button:
+		if w, h, err := GetFont(e.FontFamily).SizeUTF8(e.Text); err == nil {
+			return int32(w) + 2*buttonPaddingX, int32(h) + 2*buttonPaddingY
+		}
+		return defaultWidth, defaultHeight
+	case *TextField:
+		return defaultWidth, defaultHeight
+	case *Div:
+		// a div is as wide as its flow and as tall as its children
+		inner := max(width-2*divPadding, 0)
+		var h int32
+		switch display(e) {
+		case "flex":
+			h = measureFlex(e, inner, windowHeight)
+		case "grid":
+			h = measureGrid(e, inner, windowHeight)
+		default:
+			h = measureFlow(e.Children, inner, windowHeight)
+		}
+		return width, h + 2*divPadding
+	}
+	return element.GetSize()
+}
+
+// place moves a measured element to x, y, and its children with it
+func place(element UIElement, x, y, windowHeight int32) {
+	element.SetPosition(x, y)
+	if div, ok := element.(*Div); ok {
+		innerWidth := max(div.Width-2*divPadding, 0)
+		innerHeight := max(div.Height-2*divPadding, 0)
+		switch display(div) {
+		case "flex":
+			placeFlex(div, x+divPadding, y+divPadding, innerWidth, innerHeight, windowHeight)
+		case "grid":
+			placeGrid(div, x+divPadding, y+divPadding, innerWidth, windowHeight)
+		default:
+			placeFlow(div.Children, x+divPadding, y+divPadding, innerWidth, windowHeight)
+		}
+	}
+}
+
+// display is how a div lays out its children, block unless it says otherwise
+func display(div *Div) string {
+	return strings.TrimSpace(div.Styles["display"])
+}
+
+// elementMargins reads margin and the margin-left/right/up/down styles, which
+// override it for their side. Percentages are of width.
+func elementMargins(element UIElement, width int32) margins {
+	styles := elementStyles(element)
+	var m margins
+	for _, key := range []string{"margin", "padding"} {
+		if value, ok := parseLength(styles[key], width); ok {
+			m = margins{value, value, value, value}
+		}
+	}
+	sides := map[string]*int32{
+		"margin-up":    &m.top,
+		"margin-right": &m.right,
+		"margin-down":  &m.bottom,
+		"margin-left":  &m.left,
+	}
+	for key, side := range sides {
+		if value, ok := parseLength(styles[key], width); ok {
+			*side = value
+		}
+	}
+	return m
+}
+
+func elementStyles(element UIElement) map[string]string {
+	if baseEl, ok := element.(interface{ GetBaseElement() *BaseElement }); ok {
+		return baseEl.GetBaseElement().Styles
+	}
+	return nil
+}
+
+// parseLength reads a length style in px, or in % of relativeTo
+func parseLength(value string, relativeTo int32) (int32, bool) {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "px"))
+	if value == "" {
+		return 0, false
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		n, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil {
+			return 0, false
+		}
+		return int32(float64(relativeTo) * n / 100), true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return int32(n), true
+}
@@ -1,12 +1,14 @@
 package processjtl
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,7 +16,6 @@ import (
 	"jtlweb/stuff/jtltp"
 	"jtlweb/stuff/shared"
 
-	"github.com/OrtheSnowJames/jtl"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -23,7 +24,10 @@ var documentUpdate atomic.Bool
 var documentMutex sync.RWMutex
 var ObjectsMutex sync.Mutex
 var luaState *lua.LState
-var Site string
+var Site string // host:port of the open page, empty for local files
+
+// FetchClient is used for remote pages, their scripts and document.fetch
+var FetchClient = &jtltp.Client{Retries: 2, KeepAlive: true, Cache: jtltp.NewCache(64 << 20)}
 var frameHandler string          // Add this at the top with other vars
 var requestedFrameHandler string // Add this at the top with other vars
 
@@ -118,15 +122,6 @@ func updateObjectsFromDocumentStore() {
 	ObjectsMutex.Unlock()
 	fmt.Printf("Objects updated, new length: %d\n", len(objects))
 
-	// Recalculate positions of all objects and update content height
-	contentHeight := 0
-	for _, obj := range objects {
-		if baseEl, ok := obj.(interface{ GetBaseElement() *BaseElement }); ok {
-			contentHeight += int(baseEl.GetBaseElement().Height) + 20 // Add height and margin
-		}
-	}
-	shared.ContentHeight = contentHeight
-
 	// Call the callback to update main objects
 	if updateMainObjectsCallback != nil {
 		updateMainObjectsCallback(objects)
@@ -210,14 +205,45 @@ func getObjects(L *lua.LState) int {
 	return 1
 }
 
-// Will be updated later for jtltp (connection) support
+// GetRelToOpenPath resolves relpath against the open page, which is either a
+// local file or a jtltp:// URL
 func GetRelToOpenPath(relpath string) string {
+	if jtltp.IsURL(relpath) {
+		return relpath
+	}
+	if jtltp.IsURL(shared.OpenPath) {
+		base, err := url.Parse(shared.OpenPath)
+		if err != nil {
+			return relpath
+		}
+		ref, err := url.Parse(relpath)
+		if err != nil {
+			return relpath
+		}
+		return base.ResolveReference(ref).String()
+	}
+
 	// Get the directory containing the current JTL file
 	dir := filepath.Dir(shared.OpenPath)
 	// Join the directory with the relative path
 	return filepath.Join(dir, relpath)
 }
 
+// ReadRelToOpenPath reads a file referenced by the open page, from disk or
+// from the page's JTLTP server
+func ReadRelToOpenPath(relpath string) ([]byte, error) {
+	path := GetRelToOpenPath(relpath)
+	if !jtltp.IsURL(path) {
+		return os.ReadFile(path)
+	}
+
+	resp, err := FetchClient.Get(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
 // Extract all script contents from JTL document
 func extractScripts(jtlcomps []interface{}) string {
 	var scripts strings.Builder
@@ -232,9 +258,8 @@ func extractScripts(jtlcomps []interface{}) string {
 				scripts.WriteString(content)
 				scripts.WriteString("\n")
 			} else if relpath, ok := comp["src"].(string); ok {
-				path := GetRelToOpenPath(relpath)
-				fmt.Printf("path: %v\n", path)
-				script, err := os.ReadFile(path)
+				fmt.Printf("path: %v\n", GetRelToOpenPath(relpath))
+				script, err := ReadRelToOpenPath(relpath)
 				if err != nil {
 					fmt.Printf("Error reading script file: %v\n", err)
 					continue
@@ -275,14 +300,28 @@ func getElement(selector string) UIElement {
 	ObjectsMutex.Lock()
 	defer ObjectsMutex.Unlock()
 
+	elements := make([]UIElement, 0, len(objects))
 	for _, obj := range objects {
-		if baseEl, ok := obj.(interface{ GetBaseElement() *BaseElement }); ok {
+		if element, ok := obj.(UIElement); ok {
+			elements = append(elements, element)
+		}
+	}
+	return findElement(elements, selector)
+}
+
+// findElement looks for selector in elements and then in their children
+func findElement(elements []UIElement, selector string) UIElement {
+	for _, element := range elements {
+		if baseEl, ok := element.(interface{ GetBaseElement() *BaseElement }); ok {
 			el := baseEl.GetBaseElement()
 			if strings.HasPrefix(selector, ".") && el.Class == selector[1:] {
-				return obj.(UIElement)
+				return element
 			}
 			if strings.HasPrefix(selector, "#") && el.ID == selector[1:] {
-				return obj.(UIElement)
+				return element
+			}
+			if found := findElement(el.Children, selector); found != nil {
+				return found
 			}
 		}
 	}
@@ -499,6 +538,9 @@ func setupLuaEnvironment(L *lua.LState) *lua.LTable {
 	L.SetField(docTable, "addStyle", L.NewFunction(addStyle))
 	L.SetField(docTable, "removeAllStyle", L.NewFunction(removeAllStyle))
 	L.SetField(docTable, "fetch", L.NewFunction(luaWrapFetch))
+	L.SetField(docTable, "cancelFetch", L.NewFunction(luaCancelFetch))
+	L.SetField(docTable, "subscribe", L.NewFunction(luaSubscribe))
+	L.SetField(docTable, "unsubscribe", L.NewFunction(luaUnsubscribe))
 	L.SetField(docTable, "onFrame", L.NewFunction(setFrameHandler))
 	L.SetField(docTable, "requestFrame", L.NewFunction(setRequestFrameHandler))
 	return docTable
@@ -508,78 +550,96 @@ func luaWrapFetch(L *lua.LState) int {
 	// get what to get at ("here")
 	selector := L.ToString(1)
 
-	resp, err := jtltp.JtltpFetch(Site, selector)
-	if err != nil {
-		fmt.Printf("Error fetching: %v\n", err)
-		return 0
+	// relative paths are resolved against the open page, full urls go to their own host
+	target := GetRelToOpenPath(selector)
+	request := luaFetchRequest(L, selector)
+	if jtltp.IsURL(target) {
+		request.Path = ""
 	}
 
-	// convert to lua table: has {"JTLTP-STATUS": 200 (hopefully), "JTLTP-TYPE": "jtl", "JTLTP": ">>>DOCTYPE JTL..."}
-	respTable := L.NewTable()
-	statusint, err := strconv.Atoi(resp["JTLTP-STATUS"])
-	if err != nil {
-		fmt.Printf("Error converting status to int: %v\n", err)
-		return 0
+	// with a callback the fetch runs in the background and we return its id
+	for i := 2; i <= L.GetTop(); i++ {
+		switch callback := L.Get(i).(type) {
+		case *lua.LFunction, lua.LString:
+			L.Push(lua.LNumber(startFetch(target, request, callback)))
+			return 1
+		}
 	}
 
-	respTable.RawSetString("JTLTP-STATUS", lua.LNumber(statusint))
-	respTable.RawSetString("JTLTP-TYPE", lua.LString(resp["JTLTP-TYPE"]))
-	respTable.RawSetString("JTLTP-MSG", lua.LString(resp["JTLTP"]))
-	L.Push(respTable)
-	return 1
-}
-
-// MakeWebview now prepares view without creating a new window
-func MakeWebview(jtldoc string) (*Locker, []CanvasObject) {
-	luaState = lua.NewState()
-
-	// Parse JTL document
-	parsedDoc, err := jtl.Parse(jtldoc)
-	if err != nil {
-		fmt.Printf("Failed to parse JTL: %v\n", err)
-		return nil, nil
+	// without one the page waits for the answer
+	var resp *jtltp.Response
+	var err error
+	if jtltp.IsURL(target) {
+		resp, err = FetchClient.DoURL(context.Background(), target, request)
+	} else {
+		resp, err = FetchClient.Do(context.Background(), Site, request)
 	}
 
-	fmt.Printf("Parsed %d JTL components\n", len(parsedDoc))
+	// non-200 answers still go to the script, it can check the status itself
+	var statusErr *jtltp.StatusError
+	if err != nil && !errors.As(err, &statusErr) {
+		fmt.Printf("Error fetching: %v\n", err)
+		return 0
+	}
 
-	// Clear existing documents
-	clearDocuments()
+	L.Push(responseToLuaTable(L, resp))
+	return 1
+}
 
-	// Store in memory
-	for _, elem := range parsedDoc {
-		if elemMap, ok := elem.(map[string]interface{}); ok {
-			insertDocument(elemMap)
+// responseToLuaTable converts a response to {"JTLTP-STATUS": 200 (hopefully),
+// "JTLTP-TYPE": "jtl", "JTLTP": ">>>DOCTYPE JTL...", "JTLTP-HEADERS": {...}}
+func responseToLuaTable(L *lua.LState, resp *jtltp.Response) *lua.LTable {
+	respTable := L.NewTable()
+	respTable.RawSetString("JTLTP-STATUS", lua.LNumber(resp.Status))
+	respTable.RawSetString("JTLTP-TYPE", lua.LString(resp.Type))
+	respTable.RawSetString("JTLTP", lua.LString(resp.Body))
+	// older scripts read the body from here
+	respTable.RawSetString("JTLTP-MSG", lua.LString(resp.Body))
+
+	headersTable := L.NewTable()
+	for key, value := range resp.Header {
+		headersTable.RawSetString(key, lua.LString(value))
+	}
+	respTable.RawSetString("JTLTP-HEADERS", headersTable)
+	return respTable
+}
+
+// luaFetchRequest builds the request for document.fetch from its options
+// table, {method = "POST", body = "...", headers = {key = "value"}}, which
+// may come after the path or after the callback
+func luaFetchRequest(L *lua.LState, what string) *jtltp.Request {
+	request := jtltp.NewRequest(jtltp.MethodGet, what, nil)
+
+	var options *lua.LTable
+	for i := 2; i <= L.GetTop(); i++ {
+		if tbl, ok := L.Get(i).(*lua.LTable); ok {
+			options = tbl
+			break
 		}
 	}
-
-	// Create objects from all documents
-	allDocs := getAllDocuments()
-	if len(allDocs) == 0 {
-		fmt.Println("No documents retrieved from database")
-		return nil, nil
+	if options == nil {
+		return request
 	}
 
-	objects = ToRaylib(allDocs)
-	if len(objects) == 0 {
-		fmt.Println("No objects created from documents")
-		return nil, nil
+	if method := options.RawGetString("method"); method != lua.LNil {
+		request.Method = strings.ToUpper(method.String())
 	}
-
-	fmt.Printf("Created %d objects\n", len(objects))
-
-	// Extract and run scripts after objects are created
-	combinedScript := extractScripts(parsedDoc)
-
-	// Setup initial Lua environment
-	docTable := setupLuaEnvironment(luaState)
-	luaState.SetGlobal("document", docTable)
-	Site = ""
-	// Execute script after objects are created and stored
-	if err := luaState.DoString(combinedScript); err != nil {
-		fmt.Printf("Initial script execution error: %v\n", err)
+	if body := options.RawGetString("body"); body != lua.LNil {
+		request.Body = []byte(body.String())
 	}
+	if headers, ok := options.RawGetString("headers").(*lua.LTable); ok {
+		headers.ForEach(func(key, value lua.LValue) {
+			request.Header.Set(key.String(), value.String())
+		})
+	}
+	return request
+}
 
-	return newLocker(objects), objects
+// MakeWebview now prepares view without creating a new window
+func MakeWebview(jtldoc string) (*Locker, []CanvasObject) {
+	page := BeginWebview()
+	page.text.WriteString(jtldoc)
+	return page.Finish()
 }
 
 // AddStyle adds a style to an element
@@ -590,6 +650,7 @@ func addStyle(L *lua.LState) int {
 	if element := getElement(selector); element != nil {
 		if baseEl, ok := element.(interface{ GetBaseElement() *BaseElement }); ok {
 			baseEl.GetBaseElement().AddStyle(style)
+			Relayout()
 		}
 	}
 	return 0
@@ -602,6 +663,7 @@ func removeAllStyle(L *lua.LState) int {
 	if element := getElement(selector); element != nil {
 		if baseEl, ok := element.(interface{ GetBaseElement() *BaseElement }); ok {
 			baseEl.GetBaseElement().RemoveAllStyle()
+			Relayout()
 		}
 	}
 	return 0
@@ -658,6 +720,8 @@ func drawRenderingState(objects []CanvasObject) {
 	// Execute requested frame handler before drawing objects
 	executeRequestedFrameHandler()
 
+	RunFetchCallbacks()
+
 	for _, obj := range localObjects {
 		if obj == nil {
 			continue
@@ -0,0 +1,158 @@
+package processjtl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"jtlweb/stuff/jtltp"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// subscription is a document.subscribe receiving messages on its own goroutine
+type subscription struct {
+	id       int
+	callback lua.LValue // a lua function or a string of lua to run
+	cancel   context.CancelFunc
+}
+
+// subscriptionEvent is a message for a subscription's callback, or the reason
+// it ended when message is nil
+type subscriptionEvent struct {
+	sub     *subscription
+	message []byte
+	err     error
+}
+
+var subscribeMutex sync.Mutex
+var subscriptionID int
+var subscriptions = map[int]*subscription{}
+var subscriptionEvents []subscriptionEvent // waiting for RunFetchCallbacks
+
+func startSubscription(target string, callback lua.LValue) int {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	subscribeMutex.Lock()
+	subscriptionID++
+	sub := &subscription{id: subscriptionID, callback: callback, cancel: cancel}
+	subscriptions[sub.id] = sub
+	subscribeMutex.Unlock()
+
+	queue := func(event subscriptionEvent) {
+		subscribeMutex.Lock()
+		defer subscribeMutex.Unlock()
+		// unsubscribed ones don't hear anything anymore
+		if _, ok := subscriptions[sub.id]; ok {
+			subscriptionEvents = append(subscriptionEvents, event)
+		}
+	}
+
+	go func() {
+		defer cancel()
+		err := FetchClient.Subscribe(ctx, target, func(message []byte) {
+			queue(subscriptionEvent{sub: sub, message: message})
+		})
+		if err == nil {
+			err = errors.New("subscription closed by the server")
+		}
+		queue(subscriptionEvent{sub: sub, err: err})
+
+		subscribeMutex.Lock()
+		delete(subscriptions, sub.id)
+		subscribeMutex.Unlock()
+	}()
+
+	return sub.id
+}
+
+func cancelSubscription(id int) bool {
+	subscribeMutex.Lock()
+	defer subscribeMutex.Unlock()
+	sub, ok := subscriptions[id]
+	if ok {
+		delete(subscriptions, id)
+		sub.cancel()
+	}
+	return ok
+}
+
+func cancelSubscriptions() {
+	subscribeMutex.Lock()
+	defer subscribeMutex.Unlock()
+	for id, sub := range subscriptions {
+		sub.cancel()
+		delete(subscriptions, id)
+	}
+	subscriptionEvents = nil
+}
+
+func runSubscriptionEvents() {
+	subscribeMutex.Lock()
+	events := subscriptionEvents
+	subscriptionEvents = nil
+	subscribeMutex.Unlock()
+
+	for _, event := range events {
+		runSubscriptionCallback(event)
+	}
+}
+
+// runSubscriptionCallback calls callback(message, err). string callbacks find
+// them in the globals message and subscriptionError instead
+func runSubscriptionCallback(event subscriptionEvent) {
+	if luaState == nil {
+		return
+	}
+	L := luaState
+
+	var messageValue lua.LValue = lua.LNil
+	var errValue lua.LValue = lua.LNil
+	if event.err != nil {
+		fmt.Printf("Subscription ended: %v\n", event.err)
+		errValue = lua.LString(event.err.Error())
+	} else {
+		messageValue = lua.LString(event.message)
+	}
+
+	var err error
+	switch callback := event.sub.callback.(type) {
+	case *lua.LFunction:
+		err = L.CallByParam(lua.P{Fn: callback, NRet: 0, Protect: true}, messageValue, errValue)
+	case lua.LString:
+		L.SetGlobal("message", messageValue)
+		L.SetGlobal("subscriptionError", errValue)
+		err = L.DoString(string(callback))
+	}
+	if err != nil {
+		fmt.Printf("Error executing subscription handler: %v\n", err)
+	}
+}
+
+// luaSubscribe is document.subscribe(path, handler), it returns an id for
+// document.unsubscribe
+func luaSubscribe(L *lua.LState) int {
+	target := GetRelToOpenPath(L.ToString(1))
+	if !jtltp.IsURL(target) {
+		fmt.Printf("Error subscribing: %s is not on a jtltp server\n", target)
+		return 0
+	}
+
+	callback := L.Get(2)
+	switch callback.(type) {
+	case *lua.LFunction, lua.LString:
+	default:
+		L.ArgError(2, "handler expected")
+		return 0
+	}
+	L.Push(lua.LNumber(startSubscription(target, callback)))
+	return 1
+}
+
+// luaUnsubscribe is document.unsubscribe(id), it returns true if the
+// subscription was still open
+func luaUnsubscribe(L *lua.LState) int {
+	L.Push(lua.LBool(cancelSubscription(L.ToInt(1))))
+	return 1
+}
@@ -3,8 +3,12 @@ package processjtl
 import (
 	"fmt"
 	"jtlweb/stuff/shared"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/ttf"
 )
 
 type Text struct {
@@ -14,6 +18,17 @@ type Text struct {
 	Margin      int32
 	Center      bool
 	Rotation    float64 // Add Rotation field
+
+	// set by the layout, see wrap
+	lines      []textLine
+	lineHeight int32
+}
+
+// textLine is one line of a wrapped Text
+type textLine struct {
+	text  string
+	width int32
+	last  bool // the last line of a paragraph, which justify leaves alone
 }
 
 func NewText(content string, x, y, fontSize int32, color sdl.Color) *Text {
@@ -21,7 +36,7 @@ func NewText(content string, x, y, fontSize int32, color sdl.Color) *Text {
 		BaseElement: BaseElement{
 			X:          x,
 			Y:          y,
-			Width:      0, // Will be set when drawing
+			Width:      0, // Will be set by the layout
 			Height:     fontSize,
 			Color:      color,
 			FontFamily: "DejaVuSans", // default font
@@ -31,117 +46,205 @@ func NewText(content string, x, y, fontSize int32, color sdl.Color) *Text {
 	}
 }
 
-func (t *Text) Draw() {
-	if t.Rotation != 0 {
-		font := GetFontWithSize(t.FontFamily, int(t.FontSize))
-		var contents string
-		if t.Content == "" {
-			contents = "Blank String..."
-		} else {
-			contents = t.Content
-		}
-
-		// Create surface for measuring text dimensions
-		surface, err := font.RenderUTF8Blended(contents, t.Color)
+// wrap breaks the text into the lines it's drawn as and returns the size they
+// take. Lines break at spaces to stay within width, a word too long for a line
+// of its own is split wherever it has to be, and every newline in the text
+// starts a new line. A width of 0 doesn't wrap at all.
+func (t *Text) wrap(width int32) (int32, int32) {
+	font := GetFontWithSize(t.FontFamily, int(t.FontSize))
+	lineWidth := func(line string) int32 {
+		w, _, err := font.SizeUTF8(line)
 		if err != nil {
-			return
+			return 0
 		}
-		textWidth := int32(surface.W)
-		textHeight := int32(surface.H)
-		surface.Free()
+		return int32(w)
+	}
+	fits := func(line string) bool {
+		return width <= 0 || lineWidth(line) <= width
+	}
 
-		// Create texture for rotation
-		texture, err := Renderer.CreateTexture(sdl.PIXELFORMAT_RGBA8888,
-			sdl.TEXTUREACCESS_TARGET, textWidth, textHeight)
-		if err != nil {
-			return
-		}
-		defer texture.Destroy()
-
-		// Set render target to texture
-		prevTarget := Renderer.GetRenderTarget()
-		Renderer.SetRenderTarget(texture)
-		Renderer.SetDrawColor(240, 240, 240, 0) // Transparent background
-		Renderer.Clear()
-
-		// Render text to texture
-		surface, err = font.RenderUTF8Blended(contents, t.Color)
-		if err == nil {
-			textTexture, err := Renderer.CreateTextureFromSurface(surface)
-			if err == nil {
-				Renderer.Copy(textTexture, nil, &sdl.Rect{X: 0, Y: 0, W: textWidth, H: textHeight})
-				textTexture.Destroy()
+	content := t.Content
+	if content == "" {
+		content = blankTextContent
+	}
+	t.lines = t.lines[:0]
+	for _, paragraph := range strings.Split(content, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line == "" && fits(word) {
+				line = word
+				continue
+			}
+			if line != "" && fits(line+" "+word) {
+				line += " " + word
+				continue
+			}
+			if line != "" {
+				t.lines = append(t.lines, textLine{line, lineWidth(line), false})
 			}
-			surface.Free()
+			for !fits(word) && utf8.RuneCountInString(word) > 1 {
+				cut := fittingPrefix(word, fits)
+				t.lines = append(t.lines, textLine{word[:cut], lineWidth(word[:cut]), false})
+				word = word[cut:]
+			}
+			line = word
 		}
+		t.lines = append(t.lines, textLine{line, lineWidth(line), true})
+	}
 
-		// Reset target and draw rotated texture
-		Renderer.SetRenderTarget(prevTarget)
+	t.lineHeight = textLineHeight(font, t.Styles["line-height"])
+	var w int32
+	for _, line := range t.lines {
+		w = max(w, line.width)
+	}
+	if align := strings.TrimSpace(t.Styles["text-align"]); align != "" && align != "left" && width > 0 {
+		// there has to be room to move the lines around in
+		w = max(w, width)
+	}
+	return w, t.lineHeight * int32(len(t.lines))
+}
 
-		// Calculate position
-		x := t.X + int32(shared.OffX) + t.Margin
-		y := t.Y + int32(shared.OffY) + t.Margin
-		if t.Center {
-			windowWidth, windowHeight := Window.GetSize()
-			x = (windowWidth-textWidth)/2 + int32(shared.OffX)
-			y = (windowHeight-textHeight)/2 + int32(shared.OffY)
+// fittingPrefix returns the length in bytes of the longest start of word that
+// fits, at least one character
+func fittingPrefix(word string, fits func(string) bool) int {
+	_, cut := utf8.DecodeRuneInString(word)
+	for i := range word {
+		if i <= cut {
+			continue
+		}
+		if !fits(word[:i]) {
+			break
 		}
+		cut = i
+	}
+	return cut
+}
 
-		dstRect := &sdl.Rect{
-			X: x,
-			Y: y,
-			W: textWidth,
-			H: textHeight,
+// textLineHeight reads a line-height style: px like other lengths, % of the
+// font's own line height, or a number followed by x to multiply it by
+func textLineHeight(font *ttf.Font, value string) int32 {
+	lineSkip := int32(font.LineSkip())
+	if factor, ok := strings.CutSuffix(strings.TrimSpace(value), "x"); ok {
+		if n, err := strconv.ParseFloat(strings.TrimSpace(factor), 64); err == nil && n > 0 {
+			return int32(float64(lineSkip) * n)
 		}
+	} else if height, ok := parseLength(value, lineSkip); ok && height > 0 {
+		return height
+	}
+	return lineSkip
+}
+
+func (t *Text) Draw() {
+	if t.lines == nil {
+		// never laid out
+		t.Width, t.Height = t.wrap(0)
+	}
 
-		texture.SetBlendMode(sdl.BLENDMODE_BLEND)
-		Renderer.CopyEx(texture, nil, dstRect, t.Rotation, nil, sdl.FLIP_NONE)
-
-		// Store the actual dimensions
-		t.Width = textWidth
-		t.Height = textHeight
-	} else {
-		// Original non-rotated drawing code
-		font := GetFontWithSize(t.FontFamily, int(t.FontSize))
-		var contents string
-		if t.Content == "" {
-			contents = "Blank String..."
-		} else {
-			contents = t.Content
+	// Center the text if the center style is applied
+	x := t.X + int32(shared.OffX) + t.Margin
+	y := t.Y + int32(shared.OffY) + t.Margin
+	if t.Center {
+		windowWidth, windowHeight := Window.GetSize()
+		x = (windowWidth-t.Width)/2 + int32(shared.OffX)
+		y = (windowHeight-t.Height)/2 + int32(shared.OffY)
+	}
+
+	if t.Rotation == 0 {
+		t.drawLines(x, y)
+		return
+	}
+
+	// Create texture for rotation
+	texture, err := Renderer.CreateTexture(sdl.PIXELFORMAT_RGBA8888,
+		sdl.TEXTUREACCESS_TARGET, t.Width, t.Height)
+	if err != nil {
+		return
+	}
+	defer texture.Destroy()
+
+	// Set render target to texture
+	prevTarget := Renderer.GetRenderTarget()
+	Renderer.SetRenderTarget(texture)
+	Renderer.SetDrawColor(240, 240, 240, 0) // Transparent background
+	Renderer.Clear()
+	t.drawLines(0, 0)
+
+	// Reset target and draw rotated texture
+	Renderer.SetRenderTarget(prevTarget)
+	dstRect := &sdl.Rect{
+		X: x,
+		Y: y,
+		W: t.Width,
+		H: t.Height,
+	}
+	texture.SetBlendMode(sdl.BLENDMODE_BLEND)
+	Renderer.CopyEx(texture, nil, dstRect, t.Rotation, nil, sdl.FLIP_NONE)
+}
+
+// drawLines draws the wrapped lines from x, y, aligned within the text's width
+func (t *Text) drawLines(x, y int32) {
+	font := GetFontWithSize(t.FontFamily, int(t.FontSize))
+	// lines taller than the font keep the text in their middle
+	y += (t.lineHeight - int32(font.Height())) / 2
+
+	align := strings.TrimSpace(t.Styles["text-align"])
+	for i, line := range t.lines {
+		lineY := y + int32(i)*t.lineHeight
+		switch align {
+		case "center":
+			t.drawString(font, line.text, x+(t.Width-line.width)/2, lineY)
+		case "right":
+			t.drawString(font, line.text, x+t.Width-line.width, lineY)
+		case "justify":
+			t.drawJustified(font, line, x, lineY)
+		default:
+			t.drawString(font, line.text, x, lineY)
 		}
-		surface, err := font.RenderUTF8Blended(contents, t.Color)
-		if err == nil {
-			texture, err := Renderer.CreateTextureFromSurface(surface)
-			if err == nil {
-				// Update width and height based on rendered text
-				textWidth := int32(surface.W)
-				textHeight := int32(surface.H)
-
-				// Center the text if the center style is applied
-				x := t.X + int32(shared.OffX) + t.Margin
-				y := t.Y + int32(shared.OffY) + t.Margin
-				if t.Center {
-					windowWidth, windowHeight := Window.GetSize()
-					x = (windowWidth-textWidth)/2 + int32(shared.OffX)
-					y = (windowHeight-textHeight)/2 + int32(shared.OffY)
-				}
-
-				textRect := &sdl.Rect{
-					X: x,
-					Y: y,
-					W: textWidth,
-					H: textHeight,
-				}
-				Renderer.Copy(texture, nil, textRect)
-				texture.Destroy()
-
-				// Store the actual dimensions
-				t.Width = textWidth
-				t.Height = textHeight
-			}
-			surface.Free()
+	}
+}
+
+// drawJustified spreads the words of a line out over the text's width
+func (t *Text) drawJustified(font *ttf.Font, line textLine, x, y int32) {
+	words := strings.Fields(line.text)
+	widths := make([]int32, len(words))
+	var total int32
+	for i, word := range words {
+		if w, _, err := font.SizeUTF8(word); err == nil {
+			widths[i] = int32(w)
 		}
+		total += widths[i]
+	}
+	if line.last || len(words) < 2 || total >= t.Width {
+		t.drawString(font, line.text, x, y)
+		return
+	}
+
+	space := (t.Width - total) / int32(len(words)-1)
+	for i, word := range words {
+		t.drawString(font, word, x, y)
+		x += widths[i] + space
+	}
+}
+
+func (t *Text) drawString(font *ttf.Font, text string, x, y int32) {
+	if text == "" {
+		return
+	}
+	surface, err := font.RenderUTF8Blended(text, t.Color)
+	if err != nil {
+		return
 	}
+	defer surface.Free()
+	texture, err := Renderer.CreateTextureFromSurface(surface)
+	if err != nil {
+		return
+	}
+	Renderer.Copy(texture, nil, &sdl.Rect{X: x, Y: y, W: int32(surface.W), H: int32(surface.H)})
+	texture.Destroy()
+}
+
+func (t *Text) GetBaseElement() *BaseElement {
+	return &t.BaseElement
 }
 
 func (t *Text) CheckClick() {
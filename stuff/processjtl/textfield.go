@@ -3,6 +3,7 @@ package processjtl
 import (
 	"fmt"
 	"jtlweb/stuff/shared"
+	"unicode/utf8"
 
 	"github.com/veandco/go-sdl2/sdl"
 )
@@ -192,21 +193,31 @@ func (t *TextField) HandleInput(event *sdl.KeyboardEvent) bool {
 	switch event.Keysym.Sym {
 	case sdl.K_BACKSPACE:
 		if len(t.Text) > 0 {
-			t.Text = t.Text[:len(t.Text)-1]
+			_, size := utf8.DecodeLastRuneInString(t.Text)
+			t.Text = t.Text[:len(t.Text)-size]
 		}
 	case sdl.K_RETURN:
 		if t.OnSubmit != nil {
 			t.OnSubmit(t.Text)
 		}
 		return true
-	default:
-		if event.Keysym.Sym >= 32 && event.Keysym.Sym <= 126 {
-			t.Text += string(event.Keysym.Sym)
-		}
 	}
 	return false
 }
 
+// HandleTextInput appends typed text. Key codes alone can't tell ':' from
+// ';' or 'A' from 'a', so printable input comes from text input events.
+func (t *TextField) HandleTextInput(event *sdl.TextInputEvent) {
+	if !t.Focused {
+		return
+	}
+	t.Text += event.GetText()
+}
+
+func (t *TextField) GetBaseElement() *BaseElement {
+	return &t.BaseElement
+}
+
 // Implement the String method for TextField
 func (t *TextField) String() string {
 	return fmt.Sprintf("TextField{Text: %s, X: %d, Y: %d, Width: %d, Height: %d}", t.Text, t.X, t.Y, t.Width, t.Height)